@@ -9,14 +9,28 @@ type Menu struct {
 // A menu Item Represents a single menu aggregated menu item
 // that we will return to the user
 type MenuItem struct {
-	ID             string      `json:"id,omitempty"`
-	Name           string      `json:"name,omitempty"`
-	Description    string      `json:"description,omitempty"`
-	Disabled       bool        `json:"disabled,omitempty"`
-	CategoryID     string      `json:"category_id,omitempty"`
-	Category       *Category   `json:"category,omitempty"`
-	ModifierListID string      `json:"-"`
-	Modifiers      []*Modifier `json:"modifiers,omitempty"`
+	ID             string       `json:"id,omitempty"`
+	Name           string       `json:"name,omitempty"`
+	Description    string       `json:"description,omitempty"`
+	Disabled       bool         `json:"disabled,omitempty"`
+	CategoryID     string       `json:"category_id,omitempty"`
+	Category       *Category    `json:"category,omitempty"`
+	ModifierListID string       `json:"-"`
+	Modifiers      []*Modifier  `json:"modifiers,omitempty"`
+	Variations     []*Variation `json:"variations,omitempty"`
+	// nil when the provider doesn't say, the item is then always available
+	Availability *Availability `json:"availability,omitempty"`
+	// empty when the provider doesn't say, the item is then available for every mode
+	HandoffModes HandoffModes `json:"handoff_modes,omitempty"`
+}
+
+// A Variation is one purchasable version of a menu item
+// EX: a shirt comes in small, medium and large and each can have its own price
+type Variation struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	SKU   string `json:"sku,omitempty"`
+	Price Money  `json:"price"`
 }
 type Category struct {
 	ID       string `json:"id"`
@@ -24,18 +38,51 @@ type Category struct {
 	Disabled bool   `json:"disabled"`
 }
 
-// Modifier represents a modification to the original item
-// Add two xml tags to make the decoding easier for times sake would
-// try and keep this implementation out of final code on a real project.
-type Modifier struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Disabled bool    `json:"disabled"`
-	Cost     float64 `json:"cost,omitempty"`
-	Options  []struct {
-		Name string  `xml:"name,attr" json:"name"`
-		Cost float64 `xml:"cost,attr" json:"cost"`
-	} `json:"options"`
+// Modifier represents a modification to the original item,
+// it is the top level ModifierGroup attached to a menu item
+type Modifier = ModifierGroup
+
+// ModifierGroup is a named set of options the customer chooses from
+// EX: "Choose Flavors:" holds an option for every sauce
+type ModifierGroup struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Disabled bool              `json:"disabled"`
+	Rules    SelectionRules    `json:"rules"`
+	Options  []*ModifierOption `json:"options"`
+}
+
+// Selection types for a modifier group
+const (
+	SelectionSingle   = "SINGLE"
+	SelectionMultiple = "MULTIPLE"
+)
+
+// SelectionRules are the normalized ordering rules for a modifier group
+// so a client can render a valid selection UI no matter which provider the menu came from
+type SelectionRules struct {
+	// SINGLE when exactly one option can be picked, MULTIPLE otherwise
+	SelectionType string `json:"selection_type"`
+	// Mandatory groups need at least one option picked
+	Mandatory  bool `json:"mandatory"`
+	MinSelects int  `json:"min_selects"`
+	// 0 means there is no limit
+	MaxSelects int `json:"max_selects"`
+	// Limits on the total quantity across every picked option EX: 10 wings split across two flavors
+	// 0 means there is no limit
+	MinQuantity int `json:"min_quantity,omitempty"`
+	MaxQuantity int `json:"max_quantity,omitempty"`
+}
+
+// ModifierOption is a single choice inside a group, choosing it can open
+// more groups of its own to any depth EX: "10 Boneless" opens "Choose Flavors:"
+type ModifierOption struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Disabled  bool             `json:"disabled"`
+	Default   bool             `json:"default"`
+	Cost      Money            `json:"cost"`
+	Modifiers []*ModifierGroup `json:"modifiers,omitempty"`
 }
 
 // This is our store info
@@ -50,14 +97,34 @@ type ProviderInfo struct {
 	Telephone      string          `json:"telephone"`
 	Longitude      float64         `json:"longitude"`
 	Latitude       float64         `json:"latitude"`
+	Timezone       string          `json:"timezone,omitempty"`
 	StoreHours     []*ProviderHour `json:"store_hours"`
+	OverrideHours  []*OverrideHour `json:"override_hours,omitempty"`
+	HandoffModes   HandoffModes    `json:"handoff_modes,omitempty"`
 	PaymentMethods []string        `json:"payment_methods"`
 }
 
 // Coupled with Provider Info
+// Opens and closes are on the stores wall clock (see ProviderInfo.Timezone),
+// a close at or before the open runs overnight, so opens equal to closes EX: 00:00 to 00:00 is open 24 hours,
+// and 24:00:00 is midnight at the end of the day
+// An empty Type means the hours apply to every handoff mode
 type ProviderHour struct {
-	Type      string `json:"type"`
-	DayOfWeek string `json:"day_of_week"`
-	Opens     string `json:"opens"`
-	Closes    string `json:"closes"`
+	Type      HandoffMode `json:"type"`
+	DayOfWeek Weekday     `json:"day_of_week"`
+	Opens     TimeOfDay   `json:"opens"`
+	Closes    TimeOfDay   `json:"closes"`
 }
+
+// OverrideHour replaces the weekly hours of its type on one date EX: closed for Thanksgiving
+// Date is the stores local calendar date formatted with DateLayout,
+// opens and closes being equal means the store is closed all day
+type OverrideHour struct {
+	Type   HandoffMode `json:"type"`
+	Date   string      `json:"date"`
+	Opens  TimeOfDay   `json:"opens"`
+	Closes TimeOfDay   `json:"closes"`
+}
+
+// DateLayout is the format of a calendar date with no time or timezone
+const DateLayout = "2006-01-02"
@@ -0,0 +1,183 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Availability is when a menu item can be ordered
+// A nil start or end date leaves that side open, no Times means any time of the week
+// The dates are instants, a provider reads a local upstream date in the stores timezone so both ends are inclusive
+// and compare correctly whatever zone the time we check is in
+type Availability struct {
+	StartDate *time.Time   `json:"start_date,omitempty"`
+	EndDate   *time.Time   `json:"end_date,omitempty"`
+	Times     []*TimeRange `json:"times,omitempty"`
+}
+
+// TimeRange is a weekly window EX: Monday 00:00 to Friday 23:59
+// A range whose end is earlier in the week than its start wraps around the weekend
+type TimeRange struct {
+	From WeekTime `json:"from"`
+	To   WeekTime `json:"to"`
+}
+
+// WeekTime is a time of day on a day of the week
+type WeekTime struct {
+	Day  Weekday   `json:"day"`
+	Time TimeOfDay `json:"time"`
+}
+
+// AvailableAt reports whether the item can be ordered at t,
+// t is read on its own wall clock so callers should convert it to the stores timezone first
+func (a *Availability) AvailableAt(t time.Time) bool {
+	if a == nil {
+		return true
+	}
+
+	if a.StartDate != nil && t.Before(*a.StartDate) {
+		return false
+	}
+
+	if a.EndDate != nil && t.After(*a.EndDate) {
+		return false
+	}
+
+	if len(a.Times) == 0 {
+		return true
+	}
+
+	// compare in minutes so an end of 23:59 covers the whole last minute
+	now := minuteOfWeek(Weekday(t.Weekday()), TimeOf(t))
+	for _, r := range a.Times {
+		from := minuteOfWeek(r.From.Day, r.From.Time)
+		to := minuteOfWeek(r.To.Day, r.To.Time)
+
+		if from <= to && now >= from && now <= to {
+			return true
+		}
+
+		if from > to && (now >= from || now <= to) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func minuteOfWeek(d Weekday, t TimeOfDay) int {
+	return int(d)*24*60 + int(t)/60
+}
+
+// AvailableAt returns a copy of the menu with only the items that can be ordered at t
+func (m *Menu) AvailableAt(t time.Time) *Menu {
+	filtered := *m
+	filtered.MenuItems = make([]*MenuItem, 0, len(m.MenuItems))
+
+	for _, mi := range m.MenuItems {
+		if mi.Availability.AvailableAt(t) {
+			filtered.MenuItems = append(filtered.MenuItems, mi)
+		}
+	}
+
+	return &filtered
+}
+
+// Weekday is a day of the week that reads and writes the short upper case form EX: MON
+type Weekday time.Weekday
+
+var weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// ParseWeekday reads a day in any case, either short or long EX: MON, Monday, monday
+func ParseWeekday(s string) (Weekday, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		for i, d := range weekdays {
+			if strings.HasPrefix(s, d) && strings.HasPrefix(strings.ToUpper(time.Weekday(i).String()), s) {
+				return Weekday(i), nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("WeekdayParseErr: %q is not a day of the week", s)
+}
+
+func (d Weekday) String() string {
+	if d < 0 || int(d) >= len(weekdays) {
+		return "Weekday(" + strconv.Itoa(int(d)) + ")"
+	}
+
+	return weekdays[d]
+}
+
+func (d Weekday) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Weekday) UnmarshalText(b []byte) error {
+	v, err := ParseWeekday(string(b))
+	if err != nil {
+		return err
+	}
+
+	*d = v
+
+	return nil
+}
+
+// TimeOfDay is the number of seconds since midnight, it reads HH:MM or HH:MM:SS and writes HH:MM:SS
+// 24:00 is allowed and means the very end of the day
+type TimeOfDay int
+
+// EndOfDay is midnight at the end of the day, written as 24:00:00
+const EndOfDay = TimeOfDay(24 * 60 * 60)
+
+// ParseTimeOfDay reads a wall clock time EX: 09:00, 09:00:00 or 24:00
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("TimeOfDayParseErr: %q is not a time of day", s)
+	}
+
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("TimeOfDayParseErr: %q is not a time of day", s)
+		}
+		n[i] = v
+	}
+
+	t := TimeOfDay(n[0]*60*60 + n[1]*60 + n[2])
+	if n[1] > 59 || n[2] > 59 || t > EndOfDay {
+		return 0, fmt.Errorf("TimeOfDayParseErr: %q is out of range", s)
+	}
+
+	return t, nil
+}
+
+// TimeOf returns the time of day of t on its own wall clock
+func TimeOf(t time.Time) TimeOfDay {
+	return TimeOfDay(t.Hour()*60*60 + t.Minute()*60 + t.Second())
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d", int(t)/3600, int(t)/60%60, int(t)%60)
+}
+
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *TimeOfDay) UnmarshalText(b []byte) error {
+	v, err := ParseTimeOfDay(string(b))
+	if err != nil {
+		return err
+	}
+
+	*t = v
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAvailableAtDates(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// a holiday special from the start of Dec 1st to the start of Dec 31st in the store
+	start := time.Date(2020, 12, 1, 0, 0, 0, 0, ny)
+	end := time.Date(2020, 12, 31, 0, 0, 0, 0, ny)
+	a := &Availability{StartDate: &start, EndDate: &end}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"second before the start", start.Add(-time.Second), false},
+		{"at the start", start, true},
+		{"at the end", end, true},
+		{"second after the end", end.Add(time.Second), false},
+		// 3am UTC on Dec 1st is still Nov 30th in New York, read as UTC it would look available
+		{"start in utc", time.Date(2020, 12, 1, 3, 0, 0, 0, time.UTC), false},
+		{"start in utc once open", time.Date(2020, 12, 1, 5, 0, 0, 0, time.UTC), true},
+		{"end in utc", time.Date(2020, 12, 31, 4, 59, 59, 0, time.UTC), true},
+		{"end in utc once over", time.Date(2020, 12, 31, 5, 0, 1, 0, time.UTC), false},
+	}
+
+	for _, tc := range cases {
+		if got := a.AvailableAt(tc.at); got != tc.want {
+			t.Errorf("%s: AvailableAt(%v) is %t, want %t", tc.name, tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestAvailableAtOpenDates(t *testing.T) {
+	at := time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC)
+	before, after := at.Add(-time.Hour), at.Add(time.Hour)
+
+	cases := []struct {
+		name string
+		a    *Availability
+		want bool
+	}{
+		{"nil", nil, true},
+		{"no dates", &Availability{}, true},
+		{"only a start", &Availability{StartDate: &before}, true},
+		{"only a start later", &Availability{StartDate: &after}, false},
+		{"only an end", &Availability{EndDate: &after}, true},
+		{"only an end earlier", &Availability{EndDate: &before}, false},
+	}
+
+	for _, tc := range cases {
+		if got := tc.a.AvailableAt(at); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAvailableAtTimes(t *testing.T) {
+	weekdays := &Availability{Times: []*TimeRange{{
+		From: WeekTime{Day: Weekday(time.Monday), Time: 0},
+		To:   WeekTime{Day: Weekday(time.Friday), Time: 23*60*60 + 59*60},
+	}}}
+	// Friday night into Monday morning wraps around the end of the week
+	weekend := &Availability{Times: []*TimeRange{{
+		From: WeekTime{Day: Weekday(time.Friday), Time: 17 * 60 * 60},
+		To:   WeekTime{Day: Weekday(time.Monday), Time: 9 * 60 * 60},
+	}}}
+
+	// Nov 30th 2020 is a Monday
+	day := func(d, h, m int) time.Time { return time.Date(2020, 11, 30+d, h, m, 30, 0, time.UTC) }
+
+	cases := []struct {
+		name string
+		a    *Availability
+		at   time.Time
+		want bool
+	}{
+		{"monday midnight", weekdays, day(0, 0, 0), true},
+		{"last minute of friday", weekdays, day(4, 23, 59), true},
+		{"saturday", weekdays, day(5, 0, 0), false},
+		{"sunday night", weekdays, day(-1, 23, 59), false},
+		{"friday evening", weekend, day(4, 17, 0), true},
+		{"friday afternoon", weekend, day(4, 16, 59), false},
+		{"sunday", weekend, day(6, 12, 0), true},
+		{"monday morning", weekend, day(0, 9, 0), true},
+		{"monday after nine", weekend, day(0, 9, 1), false},
+		{"wednesday", weekend, day(2, 12, 0), false},
+	}
+
+	for _, tc := range cases {
+		if got := tc.a.AvailableAt(tc.at); got != tc.want {
+			t.Errorf("%s: AvailableAt(%v) is %t, want %t", tc.name, tc.at, got, tc.want)
+		}
+	}
+}
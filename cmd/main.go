@@ -1,43 +1,404 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 
+	"github.com/ko1eda/apiaggregator/config"
 	"github.com/ko1eda/apiaggregator/http"
 	"github.com/ko1eda/apiaggregator/http/providers/koalaJsonEatery"
 	"github.com/ko1eda/apiaggregator/http/providers/koalaXmlGrill"
+	"github.com/ko1eda/apiaggregator/http/providers/mapping"
 )
 
 func main() {
-	port := flag.String("p", "8080", "Set the port the server will run on")
+	path := flag.String("c", "", "Set the json config file, leave empty to serve the golden files with the default settings")
+	port := flag.String("p", "", "Set the port the server will run on, this overrides the address in the config")
+	record := flag.String("record", "", "Save every upstream response to this directory so it can be replayed later")
+	replay := flag.String("replay", "", "Serve upstream responses from this directory instead of the network")
 
 	flag.Parse()
-	// create a new server and a new client for our providers to connect to their data sources
-	// pass in the port dyanamically with config flag and variadic argument
-	srvr := http.NewServer(http.WithAddress(":" + *port))
 
-	client := http.NewClient()
+	// the config is defaults < file < KOALA_* environment variables < flags
+	cfg, err := loadConfig(*path, *port)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	p := koalaJsonEatery.NewProvider(client)
-	p2 := koalaXmlGrill.NewProvider(client)
+	if *record != "" && *replay != "" {
+		log.Fatal("ConfigErr: -record and -replay can't be used together")
+	}
+	fx := fixtures{record: *record, replay: *replay}
 
-	// Assign Providers to server
-	srvr.JsonEateryProvider = p
-	srvr.XmlGrillProvider = p2
+	// Every location we serve is registered here by its ID,
+	// adding a new location is just another entry in the providers list of the config
+	current, err := build(cfg, nil, fx)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// open the sever (this is non blocking because we're running listener as goroutine)
-	srvr.Open()
+	srvr := http.NewServer(
+		http.WithAddress(cfg.Address),
+		http.WithRegistry(current.reg),
+		http.WithTimeouts(cfg.Server.ReadTimeout.Duration, cfg.Server.WriteTimeout.Duration, cfg.Server.IdleTimeout.Duration),
+	)
 
-	// close the server when our main function exits
-	defer srvr.Close()
+	// open the sever (this is non blocking because we're running listener as goroutine)
+	if err := srvr.Open(); err != nil {
+		log.Fatal(err)
+	}
 
 	sigchan := make(chan os.Signal, 1)
 
-	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+
+	// block until we get an os system call to stop or the server stops on its own,
+	// a SIGHUP reloads the config and keeps serving
+	for stop := false; !stop; {
+		select {
+		case sig := <-sigchan:
+			if sig == syscall.SIGHUP {
+				current = reload(srvr, current, *path, *port, fx)
+				continue
+			}
+			log.Printf("Got %v, shutting down....", sig)
+			stop = true
+		case err := <-srvr.Err():
+			log.Fatal(err)
+		}
+	}
+
+	// let in flight requests finish, but don't wait forever
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err := srvr.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// loadConfig loads the config and applies the port flag on top of it
+func loadConfig(path, port string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if port != "" {
+		cfg.Address = ":" + port
+	}
+
+	return cfg, nil
+}
+
+// loaded is a config and the registry built from it,
+// from is the config entry each registered location was built from
+// and specs the mapping spec each mapping entry was built with, by the entries location ID
+type loaded struct {
+	cfg   *config.Config
+	reg   *http.Registry
+	from  map[string]*config.Provider
+	specs map[string]*spec
+}
+
+// spec is a mapping spec file as it was read when we built the providers,
+// sum is the hash of the file so a reload can tell the spec was edited even when the config wasn't
+type spec struct {
+	*mapping.Spec
+	sum [sha256.Size]byte
+}
+
+func loadSpec(path string) (*spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MappingSpecErr: Could not read %s %w", path, err)
+	}
+
+	s, err := mapping.ParseSpec(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spec{Spec: s, sum: sha256.Sum256(b)}, nil
+}
+
+// reload reads the config again and swaps the new providers in, requests already running finish on the old ones
+// If anything goes wrong we log it and keep serving the config we had
+func reload(srvr *http.Server, current *loaded, path, port string, fx fixtures) *loaded {
+	log.Println("Got SIGHUP, reloading config....")
+
+	cfg, err := loadConfig(path, port)
+	if err != nil {
+		log.Printf("Reload failed, keeping the current config: %v", err)
+		return current
+	}
+
+	next, err := build(cfg, current, fx)
+	if err != nil {
+		log.Printf("Reload failed, keeping the current config: %v", err)
+		return current
+	}
+
+	srvr.SwapProviders(next.reg)
+
+	changes := append(config.Changes(current.cfg, cfg), current.specChanges(next)...)
+	if len(changes) == 0 {
+		log.Println("Reloaded config, nothing changed")
+	}
+	for _, c := range changes {
+		log.Println("Reloaded config, " + c)
+	}
+
+	return next
+}
+
+// fixtures is where upstream responses are recorded to or replayed from, both empty is the network as usual
+type fixtures struct {
+	record string
+	replay string
+}
+
+// getter wraps the client to record or replaces it to replay
+func (f fixtures) getter(c *http.Client) http.HttpGetter {
+	switch {
+	case f.replay != "":
+		return http.NewReplayer(f.replay)
+	case f.record != "":
+		return http.NewRecorder(c, f.record)
+	}
+
+	return c
+}
+
+// build creates every provider in the config and registers it by its location ID
+// The client serves file:// urls from the file root so the golden file providers work offline,
+// http(s) urls go over the network as usual unless fx records or replays them
+// On a reload prev is what we are running now, providers whose config didn't change are reused
+// so they keep their cache and breaker state
+func build(cfg *config.Config, prev *loaded, fx fixtures) (*loaded, error) {
+	opts := []func(*http.Client){}
+	if cfg.FileRoot != "" {
+		opts = append(opts, http.WithFileRoot(cfg.FileRoot))
+	}
+	client := fx.getter(http.NewClient(opts...))
+
+	l := &loaded{cfg: cfg, reg: http.NewRegistry(), from: make(map[string]*config.Provider), specs: make(map[string]*spec)}
+
+	// specs are read up front, the providers are built from these and a reload compares them to tell an edit
+	for _, pc := range cfg.Providers {
+		if pc.Type != config.TypeMapping {
+			continue
+		}
+		s, err := loadSpec(pc.Mapping)
+		if err != nil {
+			return nil, err
+		}
+		l.specs[pc.LocationID] = s
+	}
+
+	// entries for a single location go first so they win over the same location listed by an all_locations upstream
+	var all []*config.Provider
+	for _, pc := range cfg.Providers {
+		if pc.AllLocations {
+			all = append(all, pc)
+			continue
+		}
+
+		p := prev.unchanged(l, pc, pc.LocationID)
+		if p == nil {
+			up, err := newUpstream(pc, client, l.specs[pc.LocationID])
+			if err != nil {
+				return nil, err
+			}
+			p = wrap(up, pc)
+		}
+		if err := l.register(pc.LocationID, p, pc); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pc := range all {
+		if err := l.registerAll(pc, prev, client); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (l *loaded) register(ID string, p http.AsyncProvider, pc *config.Provider) error {
+	if err := l.reg.Register(ID, p); err != nil {
+		return err
+	}
+	l.from[ID] = pc
+
+	return nil
+}
+
+// registerAll asks the upstream for pc which locations it has and registers a provider for each one,
+// they share the upstream but each gets its own timeout, breaker and cache like any other location
+// An upstream we can't list the locations from doesn't stop the rest of the config from being served,
+// we log it and keep the locations prev was serving from the same entry, at startup that is none of them
+func (l *loaded) registerAll(pc *config.Provider, prev *loaded, client http.HttpGetter) error {
+	up, err := newUpstream(pc, client, l.specs[pc.LocationID])
+	if err != nil {
+		return err
+	}
+
+	multi, ok := up.(http.MultiLocationProvider)
+	if !ok {
+		return fmt.Errorf("ConfigErr: %s can't serve all_locations for %s", pc.Type, pc.LocationID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pc.Timeout.Duration)
+	defer cancel()
+
+	IDs, err := multi.Locations(ctx)
+	if err != nil {
+		IDs = prev.servedBy(pc)
+		log.Printf("Could not list the locations for %s, keeping the %d it was serving: %v", pc.LocationID, len(IDs), err)
+	}
+
+	var served []string
+	for _, ID := range IDs {
+		if _, hit := l.reg.Lookup(ID); hit {
+			log.Printf("Location %s from %s already has a provider, keeping that one", ID, pc.LocationID)
+			continue
+		}
+
+		p := prev.unchanged(l, pc, ID)
+		if p == nil {
+			p = wrap(multi.ForLocation(ID), pc)
+		}
+		if err := l.register(ID, p, pc); err != nil {
+			return err
+		}
+		served = append(served, ID)
+	}
+
+	log.Printf("Serving %d locations from %s: %s", len(served), pc.LocationID, strings.Join(served, ", "))
+
+	return nil
+}
+
+// servedBy lists the locations l registered from the all_locations entry pc, sorted so the log is stable
+// An entry whose config changed since serves nothing, its running providers were built for the old config
+func (l *loaded) servedBy(pc *config.Provider) []string {
+	if l == nil {
+		return nil
+	}
+
+	var IDs []string
+	for ID, from := range l.from {
+		if *from == *pc {
+			IDs = append(IDs, ID)
+		}
+	}
+	sort.Strings(IDs)
+
+	return IDs
+}
+
+// unchanged returns the running provider for location ID if the config entry it was built from
+// and its mapping spec haven't changed in next, otherwise nil
+func (l *loaded) unchanged(next *loaded, pc *config.Provider, ID string) http.AsyncProvider {
+	if l == nil || l.cfg.FileRoot != next.cfg.FileRoot {
+		return nil
+	}
+
+	old, hit := l.from[ID]
+	if !hit || *old != *pc {
+		return nil
+	}
+
+	if was, now := l.specs[old.LocationID], next.specs[pc.LocationID]; was != nil && now != nil && was.sum != now.sum {
+		return nil
+	}
+
+	p, _ := l.reg.Lookup(ID)
+
+	return p
+}
+
+// specChanges lists the mapping specs edited since l was built for the log,
+// an entry whose config changed as well is already reported by config.Changes
+func (l *loaded) specChanges(next *loaded) []string {
+	var changes []string
+	for _, pc := range next.cfg.Providers {
+		was, now := l.specs[pc.LocationID], next.specs[pc.LocationID]
+		if was == nil || now == nil || was.sum == now.sum {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("changed mapping spec %s for location %s", pc.Mapping, pc.LocationID))
+	}
+
+	return changes
+}
+
+// newUpstream builds the provider for its config entry, failed GETs are retried with backoff before the provider sees the error
+// s is the spec read for a mapping entry, it is nil for the other types
+func newUpstream(pc *config.Provider, client http.HttpGetter, s *spec) (http.AsyncProvider, error) {
+	getter := http.NewRetryGetter(client, pc.Retry.Attempts, pc.Retry.Backoff.Duration)
+
+	var p http.AsyncProvider
+	switch pc.Type {
+	case config.TypeXmlGrill:
+		opts := []func(*koalaXmlGrill.KoalaXmlGrill){
+			koalaXmlGrill.WithLocationID(pc.LocationID),
+			koalaXmlGrill.WithMenuURL(pc.MenuURL),
+		}
+		if pc.Timezone != "" {
+			opts = append(opts, koalaXmlGrill.WithTimezone(pc.Timezone))
+		}
+		p = koalaXmlGrill.NewProvider(getter, opts...)
+	case config.TypeJsonEatery:
+		p = koalaJsonEatery.NewProvider(
+			getter,
+			koalaJsonEatery.WithLocationID(pc.LocationID),
+			koalaJsonEatery.WithMenuURL(pc.MenuURL),
+			koalaJsonEatery.WithLocationURL(pc.LocationURL),
+		)
+	case config.TypeMapping:
+		if s == nil {
+			return nil, fmt.Errorf("ConfigErr: no mapping spec was read for location %s", pc.LocationID)
+		}
+		p = mapping.NewProvider(
+			getter,
+			s.Spec,
+			mapping.WithLocationID(pc.LocationID),
+			mapping.WithMenuURL(pc.MenuURL),
+			mapping.WithLocationURL(pc.LocationURL),
+			mapping.WithTimezone(pc.Timezone),
+		)
+	default:
+		return nil, fmt.Errorf("ConfigErr: unknown provider type %q for location %s", pc.Type, pc.LocationID)
+	}
+
+	return p, nil
+}
+
+// wrap gives a provider its own deadline so one slow upstream can't hold a request forever,
+// its own breaker so we stop calling it while it is failing
+// and its own cache so the ttls can differ EX: a menu that rarely changes can be kept longer
+// A ttl and stale of 0 leaves the cache out so every request goes to the upstream
+func wrap(p http.AsyncProvider, pc *config.Provider) http.AsyncProvider {
+	p = http.NewTimeoutProvider(p, pc.Timeout.Duration)
+	p = http.NewBreakerProvider(p, pc.Breaker.Failures, pc.Breaker.Cooldown.Duration)
+
+	if pc.Cache.TTL.Duration == 0 && pc.Cache.Stale.Duration == 0 {
+		return p
+	}
 
-	// block indefinitely for our server until we get an os system call
-	<-sigchan
+	return http.NewCacheProvider(p, pc.Cache.TTL.Duration, pc.Cache.Stale.Duration)
 }
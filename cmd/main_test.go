@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ko1eda/apiaggregator/http"
+)
+
+// reloadEnv is a config with a grill and a mapping eatery in a temp dir,
+// the spec is a copy so the test can edit it
+type reloadEnv struct {
+	config string
+	spec   string
+}
+
+func newReloadEnv(t *testing.T) *reloadEnv {
+	t.Helper()
+
+	dir := t.TempDir()
+	root, err := filepath.Abs("../goldenfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &reloadEnv{config: filepath.Join(dir, "config.json"), spec: filepath.Join(dir, "eatery.json")}
+
+	b, err := ioutil.ReadFile("../mappings/koala-json-eatery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.write(t, env.spec, string(b))
+	env.write(t, env.config, `{
+  "file_root": "`+filepath.ToSlash(root)+`",
+  "providers": [
+    {"type": "koalaXmlGrill", "location_id": "1", "menu_url": "file:///xml-grill-data.xml"},
+    {
+      "type": "mapping",
+      "location_id": "2",
+      "mapping": "`+filepath.ToSlash(env.spec)+`",
+      "menu_url": "file:///json-eatery-menu.json",
+      "location_url": "file:///json-eatery-location.json"
+    }
+  ]
+}`)
+
+	return env
+}
+
+func (e *reloadEnv) write(t *testing.T, path, body string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// edit replaces old with new in the file at path
+func (e *reloadEnv) edit(t *testing.T, path, old, new string) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(old)) {
+		t.Fatalf("%s doesn't contain %s", path, old)
+	}
+	e.write(t, path, strings.Replace(string(b), old, new, 1))
+}
+
+// start builds the config like main does and returns a server using it
+func (e *reloadEnv) start(t *testing.T) (*http.Server, *loaded) {
+	t.Helper()
+
+	cfg, err := loadConfig(e.config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := build(cfg, nil, fixtures{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return http.NewServer(http.WithRegistry(current.reg)), current
+}
+
+// logs captures the log output of fn
+func logs(fn func()) string {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	fn()
+
+	return b.String()
+}
+
+func name(t *testing.T, s *http.Server, ID string) string {
+	t.Helper()
+
+	p, hit := s.Providers().Lookup(ID)
+	if !hit {
+		t.Fatalf("location %s isn't registered", ID)
+	}
+	info, err := p.GetProviderInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Name
+}
+
+func TestReloadUnchanged(t *testing.T) {
+	env := newReloadEnv(t)
+	srvr, current := env.start(t)
+
+	var next *loaded
+	out := logs(func() { next = reload(srvr, current, env.config, "", fixtures{}) })
+
+	if !strings.Contains(out, "nothing changed") {
+		t.Errorf("logged %q, want nothing changed", out)
+	}
+	for _, ID := range []string{"1", "2"} {
+		was, _ := current.reg.Lookup(ID)
+		now, _ := next.reg.Lookup(ID)
+		if was != now {
+			t.Errorf("location %s was rebuilt without a change", ID)
+		}
+	}
+}
+
+func TestReloadSpecEdit(t *testing.T) {
+	env := newReloadEnv(t)
+	srvr, current := env.start(t)
+
+	if got := name(t, srvr, "2"); got != "Koala JSON Eatery" {
+		t.Fatalf("location 2 is %q before the edit", got)
+	}
+
+	// only the spec changes, the config is the same
+	env.edit(t, env.spec, `"name": "name",`, `"name": "business_name",`)
+
+	var next *loaded
+	out := logs(func() { next = reload(srvr, current, env.config, "", fixtures{}) })
+
+	if !strings.Contains(out, "changed mapping spec") || strings.Contains(out, "nothing changed") {
+		t.Errorf("logged %q, want the spec change", out)
+	}
+	if got := name(t, srvr, "2"); got != "Koala" {
+		t.Errorf("location 2 is %q after the edit, want the business name from the new spec", got)
+	}
+
+	// the grill didn't change so it keeps its cache and breaker
+	was, _ := current.reg.Lookup("1")
+	now, _ := next.reg.Lookup("1")
+	if was != now {
+		t.Error("location 1 was rebuilt for a spec it doesn't use")
+	}
+}
+
+func TestReloadBadConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		edit func(t *testing.T, env *reloadEnv)
+	}{
+		{"broken json", func(t *testing.T, env *reloadEnv) { env.write(t, env.config, `{"providers": [`) }},
+		{"invalid config", func(t *testing.T, env *reloadEnv) {
+			env.edit(t, env.config, `"location_id": "2"`, `"location_id": "1"`)
+		}},
+		{"broken spec", func(t *testing.T, env *reloadEnv) {
+			env.edit(t, env.spec, `"select": "locations[*]"`, `"select": "locations[*"`)
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newReloadEnv(t)
+			srvr, current := env.start(t)
+			reg := srvr.Providers()
+
+			tc.edit(t, env)
+
+			var next *loaded
+			out := logs(func() { next = reload(srvr, current, env.config, "", fixtures{}) })
+
+			if next != current || srvr.Providers() != reg {
+				t.Error("a bad reload replaced the running providers")
+			}
+			if !strings.Contains(out, "Reload failed, keeping the current config") {
+				t.Errorf("logged %q, want the reload failure", out)
+			}
+			if got := name(t, srvr, "2"); got != "Koala JSON Eatery" {
+				t.Errorf("location 2 is %q after a failed reload", got)
+			}
+		})
+	}
+}
+
+// allEnv is a config with a grill and an eatery serving every location in its list,
+// the fixtures are copied into a temp file root so the test can take the location list away
+type allEnv struct {
+	config    string
+	locations string
+}
+
+func newAllEnv(t *testing.T) *allEnv {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, f := range []string{"xml-grill-data.xml", "json-eatery-locations.json", "json-eatery-menu.json"} {
+		b, err := ioutil.ReadFile(filepath.Join("../goldenfiles", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, f), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := &allEnv{config: filepath.Join(t.TempDir(), "config.json"), locations: filepath.Join(root, "json-eatery-locations.json")}
+	err := ioutil.WriteFile(env.config, []byte(`{
+  "file_root": "`+filepath.ToSlash(root)+`",
+  "providers": [
+    {"type": "koalaXmlGrill", "location_id": "1", "menu_url": "file:///xml-grill-data.xml"},
+    {
+      "type": "koalaJsonEatery",
+      "location_id": "eateries",
+      "all_locations": true,
+      "menu_url": "file:///json-eatery-menu.json",
+      "location_url": "file:///json-eatery-locations.json",
+      "retry": {"attempts": 1}
+    }
+  ]
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return env
+}
+
+// an upstream we can't list the locations from doesn't stop the rest of the config from starting
+func TestBuildLocationsUnavailable(t *testing.T) {
+	env := newAllEnv(t)
+	if err := os.Remove(env.locations); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(env.config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var l *loaded
+	out := logs(func() { l, err = build(cfg, nil, fixtures{}) })
+	if err != nil {
+		t.Fatalf("build failed on an unreachable location list: %v", err)
+	}
+
+	if !strings.Contains(out, "Could not list the locations for eateries") {
+		t.Errorf("logged %q, want the failed location list", out)
+	}
+	if got := l.reg.IDs(); len(got) != 1 || got[0] != "1" {
+		t.Errorf("registered %v, want just the grill", got)
+	}
+}
+
+// on a reload the locations we were serving are kept when the list can't be fetched
+func TestReloadLocationsUnavailable(t *testing.T) {
+	env := newAllEnv(t)
+
+	cfg, err := loadConfig(env.config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := build(cfg, nil, fixtures{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvr := http.NewServer(http.WithRegistry(current.reg))
+
+	if err := os.Remove(env.locations); err != nil {
+		t.Fatal(err)
+	}
+
+	var next *loaded
+	out := logs(func() { next = reload(srvr, current, env.config, "", fixtures{}) })
+
+	if !strings.Contains(out, "keeping the 2 it was serving") {
+		t.Errorf("logged %q, want the kept locations", out)
+	}
+	for _, ID := range []string{"1", "2", "3"} {
+		was, _ := current.reg.Lookup(ID)
+		now, hit := next.reg.Lookup(ID)
+		if !hit || was != now {
+			t.Errorf("location %s wasn't kept", ID)
+		}
+	}
+}
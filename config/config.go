@@ -0,0 +1,429 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The provider types we know how to build, these match the provider package names
+const (
+	TypeXmlGrill   = "koalaXmlGrill"
+	TypeJsonEatery = "koalaJsonEatery"
+	// a generic provider driven by a mapping spec file, see http/providers/mapping
+	TypeMapping = "mapping"
+)
+
+// Config is everything the server needs to start, it is read from a json file
+// and any KOALA_* environment variables are applied on top (see applyEnv)
+type Config struct {
+	Address string `json:"address"`
+	// directory file:// urls are served from, leave empty to only allow http(s) upstreams
+	FileRoot  string      `json:"file_root"`
+	Server    Server      `json:"server"`
+	Providers []*Provider `json:"providers"`
+}
+
+// Server holds the http server timeouts, a read, write or idle timeout of 0s is no timeout
+// The shutdown timeout has to be positive so stopping never cuts off requests straight away or hangs forever
+type Server struct {
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	IdleTimeout  Duration `json:"idle_timeout"`
+	// how long shutdown waits for in flight requests
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// Provider is a single upstream location, anything left out gets the defaults in Default
+// With all_locations set it is every location the upstream lists instead, location_id then only names the entry
+// for environment overrides and reload logs
+type Provider struct {
+	Type         string `json:"type"`
+	LocationID   string `json:"location_id"`
+	AllLocations bool   `json:"all_locations,omitempty"`
+	MenuURL      string `json:"menu_url"`
+	LocationURL  string `json:"location_url,omitempty"`
+	// only used by the grill, it doesn't send one itself
+	Timezone string `json:"timezone,omitempty"`
+	// the spec file for a mapping provider EX: mappings/koala-json-eatery.json
+	Mapping string   `json:"mapping,omitempty"`
+	Timeout Duration `json:"timeout"`
+	Cache   Cache    `json:"cache"`
+	Retry   Retry    `json:"retry"`
+	Breaker Breaker  `json:"breaker"`
+}
+
+// Cache is how long a providers data is reused, see http.NewCacheProvider
+// A ttl and stale of 0s turns the cache off
+type Cache struct {
+	TTL   Duration `json:"ttl"`
+	Stale Duration `json:"stale"`
+}
+
+// Retry is how failed upstream GETs are retried, see http.NewRetryGetter
+type Retry struct {
+	Attempts int      `json:"attempts"`
+	Backoff  Duration `json:"backoff"`
+}
+
+// Breaker is when a providers circuit breaker opens, see http.NewBreakerProvider
+type Breaker struct {
+	Failures int      `json:"failures"`
+	Cooldown Duration `json:"cooldown"`
+}
+
+// Default values for anything a config file leaves out
+var (
+	defaultServer = Server{
+		ReadTimeout:     Duration{10 * time.Second},
+		WriteTimeout:    Duration{30 * time.Second},
+		IdleTimeout:     Duration{60 * time.Second},
+		ShutdownTimeout: Duration{15 * time.Second},
+	}
+	defaultProvider = Provider{
+		Timeout: Duration{10 * time.Second},
+		Cache:   Cache{TTL: Duration{time.Minute}, Stale: Duration{5 * time.Minute}},
+		Retry:   Retry{Attempts: 3, Backoff: Duration{100 * time.Millisecond}},
+		Breaker: Breaker{Failures: 5, Cooldown: Duration{30 * time.Second}},
+	}
+)
+
+// Default is the config we run with when no file is given,
+// it serves our two golden file locations the same way the app always has
+func Default() *Config {
+	grill, eatery := defaultProvider, defaultProvider
+	grill.Type, grill.LocationID, grill.MenuURL, grill.Timezone = TypeXmlGrill, "1", "file:///xml-grill-data.xml", "America/New_York"
+	eatery.Type, eatery.LocationID, eatery.MenuURL, eatery.LocationURL = TypeJsonEatery, "2", "file:///json-eatery-menu.json", "file:///json-eatery-location.json"
+
+	return &Config{
+		Address:   ":8080",
+		FileRoot:  "./goldenfiles",
+		Server:    defaultServer,
+		Providers: []*Provider{&grill, &eatery},
+	}
+}
+
+// UnmarshalJSON decodes the provider over the defaults, so anything the file leaves out is defaulted
+// and anything it sets is kept even when it is zero EX: a cache ttl of 0s
+func (p *Provider) UnmarshalJSON(b []byte) error {
+	// plain doesn't have this method so decoding into it doesn't call us again
+	type plain Provider
+	v := plain(defaultProvider)
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	*p = Provider(v)
+
+	return nil
+}
+
+// Load reads the config at path, or the default config if path is empty,
+// applies the environment overrides on top and validates the result
+// A setting the file leaves out gets its default, one it sets is kept as is even when it is zero
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("ConfigErr: Could not open %s %w", path, err)
+		}
+		defer f.Close()
+
+		// the server settings are decoded over the defaults, providers default themselves in UnmarshalJSON
+		c = &Config{Address: Default().Address, Server: defaultServer}
+		dec := json.NewDecoder(f)
+		// a typo in a key should be an error, not a setting we silently ignore
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("ConfigErr: Could not read %s %w", path, err)
+		}
+	}
+
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyEnv overrides the config with environment variables
+// KOALA_ADDRESS, KOALA_FILE_ROOT, KOALA_READ_TIMEOUT, KOALA_WRITE_TIMEOUT, KOALA_IDLE_TIMEOUT and KOALA_SHUTDOWN_TIMEOUT
+// set the server, a provider is changed by its location ID EX: KOALA_PROVIDER_2_MENU_URL
+// with MENU_URL, LOCATION_URL, TIMEZONE, MAPPING, TIMEOUT, CACHE_TTL, CACHE_STALE, RETRY_ATTEMPTS, RETRY_BACKOFF,
+// BREAKER_FAILURES or BREAKER_COOLDOWN after the ID
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []string
+	str := func(key string, dst *string) {
+		if v, ok := lookup(key); ok {
+			*dst = v
+		}
+	}
+	dur := func(key string, dst *Duration) {
+		if v, ok := lookup(key); ok {
+			if err := dst.parse(v); err != nil {
+				errs = append(errs, key+": "+err.Error())
+			}
+		}
+	}
+	num := func(key string, dst *int) {
+		if v, ok := lookup(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a whole number", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	str("KOALA_ADDRESS", &c.Address)
+	str("KOALA_FILE_ROOT", &c.FileRoot)
+	dur("KOALA_READ_TIMEOUT", &c.Server.ReadTimeout)
+	dur("KOALA_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	dur("KOALA_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	dur("KOALA_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	for _, p := range c.Providers {
+		if p == nil {
+			continue
+		}
+		prefix := "KOALA_PROVIDER_" + strings.ToUpper(p.LocationID) + "_"
+		str(prefix+"MENU_URL", &p.MenuURL)
+		str(prefix+"LOCATION_URL", &p.LocationURL)
+		str(prefix+"TIMEZONE", &p.Timezone)
+		str(prefix+"MAPPING", &p.Mapping)
+		dur(prefix+"TIMEOUT", &p.Timeout)
+		dur(prefix+"CACHE_TTL", &p.Cache.TTL)
+		dur(prefix+"CACHE_STALE", &p.Cache.Stale)
+		num(prefix+"RETRY_ATTEMPTS", &p.Retry.Attempts)
+		dur(prefix+"RETRY_BACKOFF", &p.Retry.Backoff)
+		num(prefix+"BREAKER_FAILURES", &p.Breaker.Failures)
+		dur(prefix+"BREAKER_COOLDOWN", &p.Breaker.Cooldown)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("ConfigErr: Bad environment override\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// Validate checks the whole config and reports every problem at once so they can all be fixed in one go
+func (c *Config) Validate() error {
+	var errs []string
+	addf := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	if c.Address == "" {
+		addf("address is required EX: :8080")
+	}
+
+	if c.FileRoot != "" {
+		if fi, err := os.Stat(c.FileRoot); err != nil || !fi.IsDir() {
+			addf("file_root %q is not a directory", c.FileRoot)
+		}
+	}
+
+	if c.Server.ReadTimeout.Duration < 0 || c.Server.WriteTimeout.Duration < 0 ||
+		c.Server.IdleTimeout.Duration < 0 || c.Server.ShutdownTimeout.Duration < 0 {
+		addf("server timeouts can't be negative")
+	} else if c.Server.ShutdownTimeout.Duration == 0 {
+		addf("server.shutdown_timeout must be positive, it is how long in flight requests get to finish")
+	}
+
+	if len(c.Providers) == 0 {
+		addf("at least one provider is required")
+	}
+
+	types := strings.Join([]string{TypeXmlGrill, TypeJsonEatery, TypeMapping}, ", ")
+	seen := make(map[string]int)
+	for i, p := range c.Providers {
+		at := fmt.Sprintf("providers[%d]", i)
+		if p == nil {
+			addf("%s is empty", at)
+			continue
+		}
+
+		if p.LocationID == "" {
+			addf("%s: location_id is required", at)
+		} else if j, dup := seen[p.LocationID]; dup {
+			addf("%s: location_id %q is already used by providers[%d]", at, p.LocationID, j)
+		} else {
+			seen[p.LocationID] = i
+		}
+
+		switch p.Type {
+		case TypeXmlGrill:
+			if p.AllLocations {
+				addf("%s: all_locations can't be used with %s, it serves one location per menu_url", at, p.Type)
+			}
+		case TypeJsonEatery:
+			if p.LocationURL == "" {
+				addf("%s: location_url is required for %s", at, p.Type)
+			}
+		case TypeMapping:
+			if p.Mapping == "" {
+				addf("%s: mapping is required for %s, it is the path to the spec file", at, p.Type)
+			} else if _, err := os.Stat(p.Mapping); err != nil {
+				addf("%s: mapping %q can't be read", at, p.Mapping)
+			}
+		case "":
+			addf("%s: type is required, one of %s", at, types)
+		default:
+			addf("%s: unknown type %q, must be one of %s", at, p.Type, types)
+		}
+
+		if p.MenuURL == "" {
+			addf("%s: menu_url is required", at)
+		}
+		if msg := checkURL(p.MenuURL, c.FileRoot); p.MenuURL != "" && msg != "" {
+			addf("%s: menu_url %s", at, msg)
+		}
+		if msg := checkURL(p.LocationURL, c.FileRoot); p.LocationURL != "" && msg != "" {
+			addf("%s: location_url %s", at, msg)
+		}
+
+		if p.Timezone != "" {
+			if _, err := time.LoadLocation(p.Timezone); err != nil {
+				addf("%s: unknown timezone %q", at, p.Timezone)
+			}
+		}
+
+		if p.Timeout.Duration <= 0 {
+			addf("%s: timeout must be positive", at)
+		} else if c.Server.WriteTimeout.Duration > 0 && p.Timeout.Duration >= c.Server.WriteTimeout.Duration {
+			// otherwise the server cuts the response off before we can report the provider timed out,
+			// without a write timeout there is nothing to cut it off
+			addf("%s: timeout %v must be shorter than server.write_timeout %v", at, p.Timeout, c.Server.WriteTimeout)
+		}
+		if p.Cache.TTL.Duration < 0 || p.Cache.Stale.Duration < 0 {
+			addf("%s: cache ttl and stale can't be negative", at)
+		}
+		if p.Retry.Attempts < 1 {
+			addf("%s: retry.attempts must be at least 1", at)
+		}
+		if p.Retry.Backoff.Duration < 0 {
+			addf("%s: retry.backoff can't be negative", at)
+		}
+		if p.Breaker.Failures < 1 {
+			addf("%s: breaker.failures must be at least 1", at)
+		}
+		if p.Breaker.Cooldown.Duration < 0 {
+			addf("%s: breaker.cooldown can't be negative", at)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("ConfigErr: Invalid config\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// checkURL returns what is wrong with an upstream url or an empty string if it is fine
+func checkURL(raw, fileRoot string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Sprintf("%q is not a url", raw)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Sprintf("%q is missing a host", raw)
+		}
+	case "file":
+		if fileRoot == "" {
+			return fmt.Sprintf("%q is a file url but file_root is not set", raw)
+		}
+	default:
+		return fmt.Sprintf("%q must be an http, https or file url", raw)
+	}
+
+	return ""
+}
+
+// Duration reads and writes a time.Duration as a string EX: "10s", "1m30s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("DurationParseErr: %s must be a string EX: \"10s\"", b)
+	}
+
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("DurationParseErr: %q is not a duration EX: 10s", s)
+	}
+
+	d.Duration = v
+
+	return nil
+}
+
+// Changes lists what is different between two configs in a form we can log EX: added location 3 (koalaJsonEatery)
+// Providers are matched by location ID, the server settings can't change without a restart so they are flagged as such
+func Changes(old, new *Config) []string {
+	var changes []string
+
+	if old.Address != new.Address {
+		changes = append(changes, fmt.Sprintf("address changed from %s to %s, this needs a restart", old.Address, new.Address))
+	}
+	if old.Server != new.Server {
+		changes = append(changes, "server timeouts changed, this needs a restart")
+	}
+	if old.FileRoot != new.FileRoot {
+		changes = append(changes, fmt.Sprintf("file_root changed from %q to %q", old.FileRoot, new.FileRoot))
+	}
+
+	before := make(map[string]*Provider, len(old.Providers))
+	for _, p := range old.Providers {
+		before[p.LocationID] = p
+	}
+
+	after := make(map[string]bool, len(new.Providers))
+	for _, p := range new.Providers {
+		after[p.LocationID] = true
+
+		was, hit := before[p.LocationID]
+		switch {
+		case !hit:
+			changes = append(changes, fmt.Sprintf("added location %s (%s)", p.LocationID, p.Type))
+		case *was != *p:
+			changes = append(changes, fmt.Sprintf("changed location %s (%s)", p.LocationID, p.Type))
+		}
+	}
+
+	for _, p := range old.Providers {
+		if !after[p.LocationID] {
+			changes = append(changes, fmt.Sprintf("removed location %s (%s)", p.LocationID, p.Type))
+		}
+	}
+
+	return changes
+}
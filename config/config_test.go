@@ -0,0 +1,277 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// write saves a config file in a temp dir with a goldenfiles directory next to it for file_root
+func write(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "goldenfiles"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	body = strings.Replace(body, "$ROOT", filepath.ToSlash(filepath.Join(dir, "goldenfiles")), -1)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// setenv sets an environment variable for the rest of the test
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+			return
+		}
+		os.Unsetenv(key)
+	})
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+const eatery = `{
+  "file_root": "$ROOT",
+  "server": { "read_timeout": "5s" },
+  "providers": [
+    {
+      "type": "koalaJsonEatery",
+      "location_id": "2",
+      "menu_url": "file:///json-eatery-menu.json",
+      "location_url": "file:///json-eatery-location.json",
+      "cache": { "ttl": "0s", "stale": "0s" },
+      "retry": { "backoff": "0s" }
+    }
+  ]
+}`
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(write(t, eatery))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Address != ":8080" {
+		t.Errorf("address is %q, want the default :8080", c.Address)
+	}
+	if c.Server.ReadTimeout.Duration != 5*time.Second || c.Server.WriteTimeout != defaultServer.WriteTimeout {
+		t.Errorf("server is %+v, want the read timeout from the file and the rest defaulted", c.Server)
+	}
+
+	p := c.Providers[0]
+	// an explicit zero is kept
+	if p.Cache.TTL.Duration != 0 || p.Cache.Stale.Duration != 0 {
+		t.Errorf("cache is %+v, want it off like the file says", p.Cache)
+	}
+	if p.Retry.Backoff.Duration != 0 {
+		t.Errorf("retry backoff is %v, want 0s from the file", p.Retry.Backoff)
+	}
+	// and anything left out is defaulted
+	if p.Retry.Attempts != defaultProvider.Retry.Attempts || p.Timeout != defaultProvider.Timeout || p.Breaker != defaultProvider.Breaker {
+		t.Errorf("got %+v, want the defaults for what the file leaves out", p)
+	}
+}
+
+// a server timeout of 0s is kept so the server runs without it
+func TestLoadZeroServerTimeouts(t *testing.T) {
+	c, err := Load(write(t, strings.Replace(eatery, `"read_timeout": "5s"`, `"read_timeout": "0s", "write_timeout": "0s", "idle_timeout": "0s"`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Server.ReadTimeout.Duration != 0 || c.Server.WriteTimeout.Duration != 0 || c.Server.IdleTimeout.Duration != 0 {
+		t.Errorf("server is %+v, want no read, write or idle timeout", c.Server)
+	}
+	if c.Server.ShutdownTimeout != defaultServer.ShutdownTimeout {
+		t.Errorf("shutdown timeout is %v, want the default", c.Server.ShutdownTimeout)
+	}
+
+	if _, err := Load(write(t, strings.Replace(eatery, `"read_timeout": "5s"`, `"shutdown_timeout": "0s"`, 1))); err == nil || !strings.Contains(err.Error(), "shutdown_timeout must be positive") {
+		t.Errorf("got %v, want a zero shutdown timeout rejected", err)
+	}
+}
+
+func TestLoadNoFile(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Providers) != 2 || c.Providers[0].Cache != defaultProvider.Cache || c.Server != defaultServer {
+		t.Errorf("got %+v, want the golden file locations with the default settings", c)
+	}
+}
+
+// the file is overridden by the environment
+func TestLoadPrecedence(t *testing.T) {
+	setenv(t, "KOALA_READ_TIMEOUT", "7s")
+	setenv(t, "KOALA_PROVIDER_2_CACHE_TTL", "30s")
+	setenv(t, "KOALA_PROVIDER_2_RETRY_ATTEMPTS", "5")
+
+	c, err := Load(write(t, eatery))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Server.ReadTimeout.Duration != 7*time.Second {
+		t.Errorf("read timeout is %v, want 7s from the environment", c.Server.ReadTimeout)
+	}
+	p := c.Providers[0]
+	if p.Cache.TTL.Duration != 30*time.Second || p.Cache.Stale.Duration != 0 {
+		t.Errorf("cache is %+v, want the ttl from the environment and stale from the file", p.Cache)
+	}
+	if p.Retry.Attempts != 5 {
+		t.Errorf("retry attempts is %d, want 5 from the environment", p.Retry.Attempts)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown field", `{"providers": [{"type": "koalaXmlGrill", "location_id": "1", "menu_url": "https://example.com", "ttl": "1m"}]}`, `unknown field "ttl"`},
+		{"unknown top level field", `{"adress": ":80"}`, `unknown field "adress"`},
+		{"bad duration", `{"providers": [{"type": "koalaXmlGrill", "location_id": "1", "menu_url": "https://example.com", "timeout": "soon"}]}`, `"soon" is not a duration`},
+		{"invalid", `{"providers": []}`, "at least one provider is required"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Load(write(t, tc.body)); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error with %s", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("a missing file did not fail")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	c := Default()
+	err := c.applyEnv(lookup(map[string]string{
+		"KOALA_ADDRESS":                     ":9090",
+		"KOALA_PROVIDER_1_MENU_URL":         "https://example.com/grill.xml",
+		"KOALA_PROVIDER_2_CACHE_STALE":      "0s",
+		"KOALA_PROVIDER_3_CACHE_TTL":        "1s",
+		"KOALA_PROVIDER_2_BREAKER_COOLDOWN": "1m",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Address != ":9090" || c.Providers[0].MenuURL != "https://example.com/grill.xml" {
+		t.Errorf("got %s %s, want the overrides", c.Address, c.Providers[0].MenuURL)
+	}
+	if p := c.Providers[1]; p.Cache.Stale.Duration != 0 || p.Cache.TTL != defaultProvider.Cache.TTL || p.Breaker.Cooldown.Duration != time.Minute {
+		t.Errorf("got %+v, want stale 0s and cooldown 1m for location 2 only", p)
+	}
+	if c.Providers[0].Cache != defaultProvider.Cache {
+		t.Errorf("location 1 cache changed to %+v", c.Providers[0].Cache)
+	}
+
+	err = Default().applyEnv(lookup(map[string]string{
+		"KOALA_READ_TIMEOUT":              "later",
+		"KOALA_PROVIDER_2_RETRY_ATTEMPTS": "three",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "KOALA_READ_TIMEOUT") || !strings.Contains(err.Error(), "KOALA_PROVIDER_2_RETRY_ATTEMPTS") {
+		t.Errorf("got %v, want both bad overrides reported", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	root := t.TempDir()
+	valid := func() *Provider {
+		p := defaultProvider
+		p.Type, p.LocationID, p.MenuURL, p.LocationURL = TypeJsonEatery, "2", "file:///menu.json", "file:///location.json"
+		return &p
+	}
+
+	cases := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"no providers", func(c *Config) { c.Providers = nil }, "at least one provider is required"},
+		{"duplicate location", func(c *Config) { c.Providers = append(c.Providers, valid()) }, `location_id "2" is already used by providers[0]`},
+		{"unknown type", func(c *Config) { c.Providers[0].Type = "koalaPizza" }, `unknown type "koalaPizza"`},
+		{"eatery without a location url", func(c *Config) { c.Providers[0].LocationURL = "" }, "location_url is required"},
+		{"mapping without a spec", func(c *Config) { c.Providers[0].Type = TypeMapping }, "mapping is required"},
+		{"grill for every location", func(c *Config) { c.Providers[0].Type, c.Providers[0].AllLocations = TypeXmlGrill, true }, "all_locations can't be used with koalaXmlGrill"},
+		{"file url without a root", func(c *Config) { c.FileRoot = "" }, "file_root is not set"},
+		{"bad scheme", func(c *Config) { c.Providers[0].MenuURL = "ftp://example.com/menu" }, "must be an http, https or file url"},
+		{"unknown timezone", func(c *Config) { c.Providers[0].Timezone = "Mars/Olympus_Mons" }, `unknown timezone "Mars/Olympus_Mons"`},
+		{"zero timeout", func(c *Config) { c.Providers[0].Timeout.Duration = 0 }, "timeout must be positive"},
+		{"timeout past the write timeout", func(c *Config) { c.Providers[0].Timeout.Duration = time.Hour }, "must be shorter than server.write_timeout"},
+		{"no write timeout", func(c *Config) { c.Server.WriteTimeout.Duration = 0; c.Providers[0].Timeout.Duration = time.Hour }, ""},
+		{"no read or idle timeout", func(c *Config) { c.Server.ReadTimeout.Duration, c.Server.IdleTimeout.Duration = 0, 0 }, ""},
+		{"negative server timeout", func(c *Config) { c.Server.IdleTimeout.Duration = -time.Second }, "server timeouts can't be negative"},
+		{"zero shutdown timeout", func(c *Config) { c.Server.ShutdownTimeout.Duration = 0 }, "server.shutdown_timeout must be positive"},
+		{"zero retry attempts", func(c *Config) { c.Providers[0].Retry.Attempts = 0 }, "retry.attempts must be at least 1"},
+		{"zero breaker failures", func(c *Config) { c.Providers[0].Breaker.Failures = 0 }, "breaker.failures must be at least 1"},
+		{"negative cache", func(c *Config) { c.Providers[0].Cache.TTL.Duration = -time.Second }, "cache ttl and stale can't be negative"},
+		{"cache off", func(c *Config) { c.Providers[0].Cache = Cache{} }, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{Address: ":8080", FileRoot: root, Server: defaultServer, Providers: []*Provider{valid()}}
+			tc.change(c)
+
+			err := c.Validate()
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("got %v, want a valid config", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error with %s", err, tc.want)
+			}
+		})
+	}
+}
+
+// every problem is reported at once
+func TestValidateReportsEverything(t *testing.T) {
+	c := &Config{Address: ":8080", Server: defaultServer, Providers: []*Provider{{}, nil}}
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("an empty provider is valid")
+	}
+	for _, want := range []string{"providers[0]: location_id is required", "providers[0]: type is required", "providers[0]: menu_url is required", "providers[1] is empty"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%v is missing %s", err, want)
+		}
+	}
+}
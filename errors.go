@@ -0,0 +1,55 @@
+package aggregator
+
+import "errors"
+
+// The kinds of error a provider can return, check for them with errors.Is
+// EX: errors.Is(err, koala.ErrUpstreamTimeout), the http package turns each one into a status code
+var (
+	// ErrNotFound is a location the upstream doesn't have
+	ErrNotFound = errors.New("not found")
+	// ErrUpstreamUnavailable is an upstream we couldn't reach or that answered with an error status
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrUpstreamTimeout is an upstream that didn't answer in time
+	ErrUpstreamTimeout = errors.New("upstream timed out")
+	// ErrDecode is an upstream response we couldn't read into our types
+	ErrDecode = errors.New("could not decode upstream response")
+	// ErrLocationMismatch is an upstream that answered for a different location than the one we asked for
+	ErrLocationMismatch = errors.New("upstream answered for a different location")
+)
+
+// Error is an error with one of the kinds above and the error that caused it,
+// Op is the prefix we have always put on our errors so the message reads the same as before
+// EX: NewError("JsonDecodeErr", ErrDecode, err) is "JsonDecodeErr: <err>" and errors.Is(e, ErrDecode) is true
+type Error struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+// NewError returns an error of the kind caused by err, err can be nil when there is nothing more to say
+func NewError(op string, kind, err error) *Error {
+	return &Error{Op: op, Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+
+	if e.Op == "" {
+		return msg
+	}
+
+	return e.Op + ": " + msg
+}
+
+// Unwrap returns the cause so errors.Is and errors.As can look past our error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the errors kind
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
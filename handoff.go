@@ -0,0 +1,108 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HandoffMode is how an order gets to the customer
+type HandoffMode string
+
+// Every handoff mode we normalize provider data into
+const (
+	HandoffPickup    HandoffMode = "pickup"
+	HandoffCurbside  HandoffMode = "curbside"
+	HandoffDelivery  HandoffMode = "delivery"
+	HandoffDriveThru HandoffMode = "drivethru"
+	HandoffDineIn    HandoffMode = "dinein"
+)
+
+// handoffAliases maps the names providers use onto our modes
+var handoffAliases = map[string]HandoffMode{
+	"pickup":    HandoffPickup,
+	"curbside":  HandoffCurbside,
+	"delivery":  HandoffDelivery,
+	"dispatch":  HandoffDelivery,
+	"drivethru": HandoffDriveThru,
+	"dinein":    HandoffDineIn,
+}
+
+// ParseHandoffMode reads a mode in any case, accepting provider names like dispatch for delivery
+func ParseHandoffMode(s string) (HandoffMode, error) {
+	if m, hit := handoffAliases[strings.ToLower(strings.TrimSpace(s))]; hit {
+		return m, nil
+	}
+
+	return "", fmt.Errorf("HandoffModeErr: %q is not a handoff mode", s)
+}
+
+// HandoffModes is the set of modes a location or item supports
+// An empty set means the provider didn't say, so we treat it as supporting every mode
+type HandoffModes []HandoffMode
+
+// Has reports whether the mode is in the set
+func (h HandoffModes) Has(m HandoffMode) bool {
+	for _, v := range h {
+		if v == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Supports is Has with an empty set allowing every mode
+func (h HandoffModes) Supports(m HandoffMode) bool {
+	return len(h) == 0 || h.Has(m)
+}
+
+// Without returns a new set with the modes removed
+func (h HandoffModes) Without(modes ...HandoffMode) HandoffModes {
+	out := HandoffModes{}
+	for _, v := range h {
+		if !HandoffModes(modes).Has(v) {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// ForHandoff returns a copy of the provider info with only the hours for the mode,
+// hours without a type apply to every mode so they are kept
+func (p *ProviderInfo) ForHandoff(m HandoffMode) *ProviderInfo {
+	filtered := *p
+	filtered.StoreHours = []*ProviderHour{}
+	for _, h := range p.StoreHours {
+		if h.Type == "" || h.Type == m {
+			filtered.StoreHours = append(filtered.StoreHours, h)
+		}
+	}
+
+	filtered.OverrideHours = nil
+	for _, o := range p.OverrideHours {
+		if o.Type == "" || o.Type == m {
+			filtered.OverrideHours = append(filtered.OverrideHours, o)
+		}
+	}
+
+	return &filtered
+}
+
+// ForHandoff returns a copy of the menu with only the items available for the mode
+// and the provider info narrowed down to that modes hours
+func (m *Menu) ForHandoff(mode HandoffMode) *Menu {
+	filtered := *m
+	if m.ProviderInfo != nil {
+		filtered.ProviderInfo = m.ProviderInfo.ForHandoff(mode)
+	}
+
+	filtered.MenuItems = make([]*MenuItem, 0, len(m.MenuItems))
+	for _, mi := range m.MenuItems {
+		if mi.HandoffModes.Supports(mode) {
+			filtered.MenuItems = append(filtered.MenuItems, mi)
+		}
+	}
+
+	return &filtered
+}
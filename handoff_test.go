@@ -0,0 +1,69 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func handoffMenu() *Menu {
+	return &Menu{
+		ProviderInfo: &ProviderInfo{
+			ID: "1",
+			StoreHours: []*ProviderHour{
+				hour(HandoffPickup, time.Monday, "11:00", "22:00"),
+				hour(HandoffDelivery, time.Monday, "17:00", "22:00"),
+				hour("", time.Tuesday, "11:00", "22:00"),
+			},
+		},
+		MenuItems: []*MenuItem{
+			// no modes is every mode
+			{ID: "burger"},
+			{ID: "wings", HandoffModes: HandoffModes{HandoffPickup, HandoffDelivery}},
+			{ID: "shake", HandoffModes: HandoffModes{HandoffDineIn}},
+		},
+	}
+}
+
+func TestMenuForHandoff(t *testing.T) {
+	cases := []struct {
+		mode  HandoffMode
+		items []string
+		hours int
+	}{
+		{HandoffPickup, []string{"burger", "wings"}, 2},
+		{HandoffDelivery, []string{"burger", "wings"}, 2},
+		{HandoffDineIn, []string{"burger", "shake"}, 1},
+		{HandoffCurbside, []string{"burger"}, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			m := handoffMenu().ForHandoff(tc.mode)
+
+			got := []string{}
+			for _, mi := range m.MenuItems {
+				got = append(got, mi.ID)
+			}
+			if !reflect.DeepEqual(got, tc.items) {
+				t.Errorf("items got %v, want %v", got, tc.items)
+			}
+			// the hours are narrowed down to the mode too, untyped hours apply to every mode
+			if len(m.ProviderInfo.StoreHours) != tc.hours {
+				t.Errorf("got %d store hours, want %d", len(m.ProviderInfo.StoreHours), tc.hours)
+			}
+		})
+	}
+}
+
+// the menu we filter is cached and shared between requests so it must not change
+func TestMenuForHandoffCopies(t *testing.T) {
+	m := handoffMenu()
+	want := handoffMenu()
+
+	m.ForHandoff(HandoffDineIn)
+
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ForHandoff changed the menu to %+v", m)
+	}
+}
@@ -0,0 +1,162 @@
+package aggregator
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StoreStatus is whether a store is open at an instant and when that next changes
+// The times are in the stores timezone, nil means there is no change within the hours we know about
+type StoreStatus struct {
+	IsOpen    bool       `json:"is_open"`
+	NextOpen  *time.Time `json:"next_open,omitempty"`
+	NextClose *time.Time `json:"next_close,omitempty"`
+}
+
+// how far ahead we look for the next open or close
+const statusHorizon = 14
+
+// Location loads the stores IANA timezone, a store that doesn't send one is treated as UTC
+func (p *ProviderInfo) Location() (*time.Location, error) {
+	if p.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("TimezoneErr: unknown timezone %q %w", p.Timezone, err)
+	}
+
+	return loc, nil
+}
+
+// StatusAt computes whether the store is open at t from its weekly hours and date overrides
+// Hours are read on the stores wall clock so daylight saving changes are handled,
+// a close at or before the open runs overnight into the next day, so equal times are open 24 hours,
+// and a close of 24:00 is midnight. An override with equal times is closed for the day instead
+func (p *ProviderInfo) StatusAt(t time.Time) (*StoreStatus, error) {
+	loc, err := p.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	t = t.In(loc)
+
+	// start the day before so a period that opened yesterday and runs overnight is included
+	start := time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, loc)
+
+	var periods []period
+	for i := 0; i <= statusHorizon; i++ {
+		day := start.AddDate(0, 0, i)
+		periods = append(periods, p.periodsOn(day)...)
+	}
+
+	// a store open around the clock merges into one period running past the days we built,
+	// we don't know when that closes so it isn't reported
+	end := start.AddDate(0, 0, statusHorizon+1)
+
+	return statusFrom(mergePeriods(periods), t, end), nil
+}
+
+// period is a single stretch of time the store is open
+type period struct {
+	opens  time.Time
+	closes time.Time
+}
+
+// periodsOn returns the open periods that start on the local day
+// An override on the date replaces the weekly hours of the same type, an override without a type replaces them all
+func (p *ProviderInfo) periodsOn(day time.Time) []period {
+	date := day.Format(DateLayout)
+
+	var periods []period
+	overridden := make(map[HandoffMode]bool)
+	for _, o := range p.OverrideHours {
+		if o.Date != date {
+			continue
+		}
+		overridden[o.Type] = true
+		// an override opening and closing at the same time is a closure EX: Thanksgiving
+		if o.Opens == o.Closes {
+			continue
+		}
+		periods = append(periods, periodOn(day, o.Opens, o.Closes))
+	}
+
+	for _, h := range p.StoreHours {
+		if time.Weekday(h.DayOfWeek) != day.Weekday() || overridden[h.Type] || overridden[""] {
+			continue
+		}
+		periods = append(periods, periodOn(day, h.Opens, h.Closes))
+	}
+
+	return periods
+}
+
+// periodOn places the hours on the local day, a close at or before the open is on the next day
+// EX: 00:00 to 00:00 is open the whole day
+func periodOn(day time.Time, opens, closes TimeOfDay) period {
+	closesDay := day
+	if closes <= opens {
+		closesDay = day.AddDate(0, 0, 1)
+	}
+
+	return period{opens: atTimeOfDay(day, opens), closes: atTimeOfDay(closesDay, closes)}
+}
+
+// atTimeOfDay builds the wall clock time on the day, letting time.Date
+// normalize 24:00 into midnight of the next day
+func atTimeOfDay(day time.Time, t TimeOfDay) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, int(t), 0, day.Location())
+}
+
+// mergePeriods sorts the periods and joins any that touch or overlap
+// EX: 11:00 to 24:00 followed by 00:00 to 02:00 is one period
+func mergePeriods(periods []period) []period {
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].opens.Before(periods[j].opens)
+	})
+
+	merged := []period{}
+	for _, p := range periods {
+		last := len(merged) - 1
+		if last >= 0 && !p.opens.After(merged[last].closes) {
+			if p.closes.After(merged[last].closes) {
+				merged[last].closes = p.closes
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	return merged
+}
+
+// statusFrom finds t in the merged periods, a close at or after end is past the hours we know about
+func statusFrom(periods []period, t, end time.Time) *StoreStatus {
+	s := &StoreStatus{}
+	for _, p := range periods {
+		closes := p.closes
+		known := closes.Before(end)
+
+		if !t.Before(p.opens) && t.Before(p.closes) {
+			s.IsOpen = true
+			if known {
+				s.NextClose = &closes
+			}
+			continue
+		}
+
+		if p.opens.After(t) {
+			opens := p.opens
+			s.NextOpen = &opens
+			if s.NextClose == nil && !s.IsOpen && known {
+				s.NextClose = &closes
+			}
+			break
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,225 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+)
+
+func hour(mode HandoffMode, day time.Weekday, opens, closes string) *ProviderHour {
+	o, _ := ParseTimeOfDay(opens)
+	c, _ := ParseTimeOfDay(closes)
+
+	return &ProviderHour{Type: mode, DayOfWeek: Weekday(day), Opens: o, Closes: c}
+}
+
+// everyDay is the same hours on every day of the week
+func everyDay(mode HandoffMode, opens, closes string) []*ProviderHour {
+	var hours []*ProviderHour
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		hours = append(hours, hour(mode, d, opens, closes))
+	}
+
+	return hours
+}
+
+func override(mode HandoffMode, date, opens, closes string) *OverrideHour {
+	o, _ := ParseTimeOfDay(opens)
+	c, _ := ParseTimeOfDay(closes)
+
+	return &OverrideHour{Type: mode, Date: date, Opens: o, Closes: c}
+}
+
+func TestStatusAt(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skip(err)
+	}
+
+	// the grill, pickup and delivery every day from 11am to midnight with pickup closed for Thanksgiving
+	grill := &ProviderInfo{
+		Timezone:      "America/New_York",
+		StoreHours:    append(everyDay(HandoffPickup, "11:00", "24:00"), everyDay(HandoffDelivery, "11:00", "24:00")...),
+		OverrideHours: []*OverrideHour{override(HandoffPickup, "2020-11-26", "00:00", "00:00")},
+	}
+
+	cases := []struct {
+		name string
+		info *ProviderInfo
+		at   string
+		open bool
+		// empty is no change within the hours we know about
+		nextOpen  string
+		nextClose string
+	}{
+		{
+			name:      "overnight before midnight",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Friday, "18:00", "02:00")}},
+			at:        "2020-11-27T23:00:00Z",
+			open:      true,
+			nextOpen:  "2020-12-04T18:00:00Z",
+			nextClose: "2020-11-28T02:00:00Z",
+		},
+		{
+			name:      "overnight after midnight",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Friday, "18:00", "02:00")}},
+			at:        "2020-11-28T01:59:59Z",
+			open:      true,
+			nextOpen:  "2020-12-04T18:00:00Z",
+			nextClose: "2020-11-28T02:00:00Z",
+		},
+		{
+			name:      "overnight once closed",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Friday, "18:00", "02:00")}},
+			at:        "2020-11-28T02:00:00Z",
+			nextOpen:  "2020-12-04T18:00:00Z",
+			nextClose: "2020-12-05T02:00:00Z",
+		},
+		{
+			name:      "close at midnight",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Monday, "11:00", "24:00")}},
+			at:        "2020-11-23T23:59:00Z",
+			open:      true,
+			nextOpen:  "2020-11-30T11:00:00Z",
+			nextClose: "2020-11-24T00:00:00Z",
+		},
+		{
+			name:      "equal open and close is 24 hours",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Monday, "00:00", "00:00")}},
+			at:        "2020-11-23T12:00:00Z",
+			open:      true,
+			nextOpen:  "2020-11-30T00:00:00Z",
+			nextClose: "2020-11-24T00:00:00Z",
+		},
+		{
+			name:      "equal open and close runs into the next day",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour("", time.Monday, "09:00", "09:00")}},
+			at:        "2020-11-24T08:00:00Z",
+			open:      true,
+			nextOpen:  "2020-11-30T09:00:00Z",
+			nextClose: "2020-11-24T09:00:00Z",
+		},
+		{
+			name: "open around the clock",
+			info: &ProviderInfo{StoreHours: everyDay("", "00:00", "00:00")},
+			at:   "2020-11-23T12:00:00Z",
+			open: true,
+		},
+		{
+			name:      "typed hours count when no mode is asked for",
+			info:      &ProviderInfo{StoreHours: []*ProviderHour{hour(HandoffDelivery, time.Monday, "11:00", "24:00")}},
+			at:        "2020-11-23T12:00:00Z",
+			open:      true,
+			nextOpen:  "2020-11-30T11:00:00Z",
+			nextClose: "2020-11-24T00:00:00Z",
+		},
+		{
+			name:      "override closes one mode and the other stays open",
+			info:      grill,
+			at:        "2020-11-26T12:00:00-05:00",
+			open:      true,
+			nextOpen:  "2020-11-27T11:00:00-05:00",
+			nextClose: "2020-11-27T00:00:00-05:00",
+		},
+		{
+			name:      "override closes the mode",
+			info:      grill.ForHandoff(HandoffPickup),
+			at:        "2020-11-26T12:00:00-05:00",
+			nextOpen:  "2020-11-27T11:00:00-05:00",
+			nextClose: "2020-11-28T00:00:00-05:00",
+		},
+		{
+			name:      "override for another mode",
+			info:      grill.ForHandoff(HandoffDelivery),
+			at:        "2020-11-26T12:00:00-05:00",
+			open:      true,
+			nextOpen:  "2020-11-27T11:00:00-05:00",
+			nextClose: "2020-11-27T00:00:00-05:00",
+		},
+		{
+			name: "override without a type replaces every mode",
+			info: &ProviderInfo{
+				StoreHours:    append(everyDay(HandoffPickup, "11:00", "24:00"), everyDay(HandoffDelivery, "11:00", "24:00")...),
+				OverrideHours: []*OverrideHour{override("", "2020-12-24", "10:00", "14:00")},
+			},
+			at:        "2020-12-24T15:00:00Z",
+			nextOpen:  "2020-12-25T11:00:00Z",
+			nextClose: "2020-12-26T00:00:00Z",
+		},
+		{
+			name:      "the instant is read on the stores clock",
+			info:      grill,
+			at:        "2020-11-25T04:30:00Z",
+			open:      true,
+			nextOpen:  "2020-11-25T11:00:00-05:00",
+			nextClose: "2020-11-25T00:00:00-05:00",
+		},
+		{
+			// clocks went from 2am to 3am on March 8th, the overnight shift is an hour shorter
+			name:      "spring forward overnight",
+			info:      &ProviderInfo{Timezone: "America/New_York", StoreHours: []*ProviderHour{hour("", time.Saturday, "22:00", "06:00")}},
+			at:        "2020-03-08T05:30:00-04:00",
+			open:      true,
+			nextOpen:  "2020-03-14T22:00:00-04:00",
+			nextClose: "2020-03-08T06:00:00-04:00",
+		},
+		{
+			name:      "spring forward opening",
+			info:      &ProviderInfo{Timezone: "America/New_York", StoreHours: []*ProviderHour{hour("", time.Sunday, "09:00", "17:00")}},
+			at:        "2020-03-08T01:30:00-05:00",
+			nextOpen:  "2020-03-08T09:00:00-04:00",
+			nextClose: "2020-03-08T17:00:00-04:00",
+		},
+		{
+			// clocks went from 2am back to 1am on November 1st
+			name:      "fall back opening",
+			info:      &ProviderInfo{Timezone: "America/New_York", StoreHours: []*ProviderHour{hour("", time.Sunday, "09:00", "17:00")}},
+			at:        "2020-11-01T08:30:00-05:00",
+			nextOpen:  "2020-11-01T09:00:00-05:00",
+			nextClose: "2020-11-01T17:00:00-05:00",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tc.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := tc.info.StatusAt(at)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s.IsOpen != tc.open {
+				t.Errorf("is_open is %t, want %t", s.IsOpen, tc.open)
+			}
+			checkTime(t, "next_open", s.NextOpen, tc.nextOpen)
+			checkTime(t, "next_close", s.NextClose, tc.nextClose)
+		})
+	}
+}
+
+func checkTime(t *testing.T, name string, got *time.Time, want string) {
+	t.Helper()
+
+	if want == "" {
+		if got != nil {
+			t.Errorf("%s is %v, want none", name, got)
+		}
+		return
+	}
+
+	w, err := time.Parse(time.RFC3339, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !got.Equal(w) {
+		t.Errorf("%s is %v, want %v", name, got, w)
+	}
+}
+
+func TestStatusAtUnknownTimezone(t *testing.T) {
+	if _, err := (&ProviderInfo{Timezone: "Mars/Olympus_Mons"}).StatusAt(time.Now()); err == nil {
+		t.Error("an unknown timezone did not fail")
+	}
+}
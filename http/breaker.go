@@ -0,0 +1,181 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// ErrBreakerOpen is returned without calling the provider while its breaker is open
+var ErrBreakerOpen = errors.New("BreakerOpenErr: provider is failing, not calling it until it cools down")
+
+// The states a breaker can be in
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed = "closed"
+	// BreakerOpen fails every call straight away
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets a single probe through to see if the provider has recovered
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus is a snapshot of a breaker for the status endpoint
+type BreakerStatus struct {
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// we do a runtime check to ensure our wrapper implements AsyncProvider
+var _ AsyncProvider = &breakerProvider{}
+
+// breakerProvider stops calling a provider that keeps failing so we don't keep hammering it
+// Info and menu calls share one breaker since they go to the same upstream
+type breakerProvider struct {
+	provider  AsyncProvider
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreakerProvider wraps p so after threshold upstream failures in a row calls fail fast with ErrBreakerOpen,
+// once cooldown has passed a single call is let through as a probe, if it works the breaker closes again
+// otherwise it stays open for another cooldown
+func NewBreakerProvider(p AsyncProvider, threshold int, cooldown time.Duration) AsyncProvider {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	return &breakerProvider{provider: p, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+func (b *breakerProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	pi, err := b.provider.GetProviderInfo(ctx)
+	b.record(ctx, probe, err)
+
+	return pi, err
+}
+
+func (b *breakerProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	menu, err := b.provider.GetFullMenu(ctx)
+	b.record(ctx, probe, err)
+
+	return menu, err
+}
+
+// Unwrap returns the provider this breaker wraps
+func (b *breakerProvider) Unwrap() AsyncProvider {
+	return b.provider
+}
+
+// BreakerStatus returns the current state of the breaker
+func (b *breakerProvider) BreakerStatus() *BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != BreakerClosed {
+		opened := b.openedAt
+		s.OpenedAt = &opened
+	}
+
+	return s
+}
+
+// allow decides if a call can go through, moving an open breaker to half open once it has cooled down
+// probe is true for the single call let through while half open
+func (b *breakerProvider) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		return false, ErrBreakerOpen
+	case BreakerHalfOpen:
+		// only one probe at a time, everyone else fails fast until we know how it went
+		if b.probing {
+			return false, ErrBreakerOpen
+		}
+		b.probing = true
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record updates the breaker with the result of a call that was let through
+func (b *breakerProvider) record(ctx context.Context, probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	// the caller going away says nothing about the provider, let the next call decide
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return
+	}
+
+	// only the upstream failing counts, anything else EX: a location it doesn't list means it answered
+	if !upstreamFailure(err) {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// upstreamFailure reports whether err says the upstream is unhealthy, it couldn't be reached,
+// didn't answer in time or sent something we couldn't read
+func upstreamFailure(err error) bool {
+	return errors.Is(err, koala.ErrUpstreamUnavailable) ||
+		errors.Is(err, koala.ErrUpstreamTimeout) ||
+		errors.Is(err, koala.ErrDecode) ||
+		isTimeout(err)
+}
+
+// breakerStatus walks down through the wrappers around p to its breaker,
+// it is nil when the provider isn't behind one
+func breakerStatus(p AsyncProvider) *BreakerStatus {
+	for p != nil {
+		if b, ok := p.(*breakerProvider); ok {
+			return b.BreakerStatus()
+		}
+
+		u, ok := p.(unwrapper)
+		if !ok {
+			return nil
+		}
+		p = u.Unwrap()
+	}
+
+	return nil
+}
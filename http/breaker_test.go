@@ -0,0 +1,147 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// cool moves when the breaker opened back by its cooldown so the next call is the probe
+func cool(b AsyncProvider) {
+	bp := b.(*breakerProvider)
+	bp.mu.Lock()
+	bp.openedAt = bp.openedAt.Add(-bp.cooldown)
+	bp.mu.Unlock()
+}
+
+func state(b AsyncProvider) string {
+	return b.(*breakerProvider).BreakerStatus().State
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	down := StatusError(500, "https://example.com")
+	p := &countingProvider{err: down}
+	b := NewBreakerProvider(p, 2, time.Minute)
+
+	// closed until the threshold is reached
+	for i := 0; i < 2; i++ {
+		if state(b) != BreakerClosed {
+			t.Fatalf("call %d: breaker is %s, want %s", i, state(b), BreakerClosed)
+		}
+		if _, err := b.GetFullMenu(context.Background()); !errors.Is(err, down) {
+			t.Fatalf("call %d got %v, want %v", i, err, down)
+		}
+	}
+
+	// open fails fast without calling the provider
+	if state(b) != BreakerOpen {
+		t.Fatalf("breaker is %s after 2 failures, want %s", state(b), BreakerOpen)
+	}
+	if _, err := b.GetProviderInfo(context.Background()); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("got %v, want ErrBreakerOpen", err)
+	}
+	if p.count() != 2 {
+		t.Fatalf("the provider was called %d times, want 2", p.count())
+	}
+
+	// a failing probe opens it for another cooldown
+	cool(b)
+	if _, err := b.GetFullMenu(context.Background()); !errors.Is(err, down) {
+		t.Fatalf("probe got %v, want %v", err, down)
+	}
+	if state(b) != BreakerOpen {
+		t.Fatalf("breaker is %s after a failed probe, want %s", state(b), BreakerOpen)
+	}
+	if _, err := b.GetFullMenu(context.Background()); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("got %v right after a failed probe, want ErrBreakerOpen", err)
+	}
+
+	// half open lets one probe through at a time
+	block := make(chan struct{})
+	p.set(nil, block)
+	cool(b)
+
+	probed := make(chan error)
+	go func() {
+		_, err := b.GetFullMenu(context.Background())
+		probed <- err
+	}()
+	p.waitFor(t, 4)
+
+	if state(b) != BreakerHalfOpen {
+		t.Fatalf("breaker is %s while probing, want %s", state(b), BreakerHalfOpen)
+	}
+	if _, err := b.GetFullMenu(context.Background()); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("got %v during the probe, want ErrBreakerOpen", err)
+	}
+
+	// and a probe that works closes it
+	close(block)
+	if err := <-probed; err != nil {
+		t.Fatalf("probe got %v", err)
+	}
+	if s := b.(*breakerProvider).BreakerStatus(); s.State != BreakerClosed || s.Failures != 0 || s.OpenedAt != nil {
+		t.Fatalf("got %+v after a good probe, want closed with no failures", s)
+	}
+	if _, err := b.GetFullMenu(context.Background()); err != nil {
+		t.Fatalf("got %v once closed", err)
+	}
+}
+
+// failures have to be in a row, a success in between starts the count again
+func TestBreakerResets(t *testing.T) {
+	down := UpstreamError(errors.New("connection refused"))
+	p := &countingProvider{}
+	b := NewBreakerProvider(p, 2, time.Minute)
+
+	for i := 0; i < 5; i++ {
+		p.set(down, nil)
+		b.GetFullMenu(context.Background())
+		p.set(nil, nil)
+		b.GetFullMenu(context.Background())
+	}
+
+	if state(b) != BreakerClosed {
+		t.Errorf("breaker is %s, want %s", state(b), BreakerClosed)
+	}
+}
+
+func TestBreakerCountsUpstreamFailures(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		counts bool
+	}{
+		{"unavailable", context.Background(), StatusError(503, "https://example.com"), true},
+		{"timeout", context.Background(), UpstreamError(context.DeadlineExceeded), true},
+		{"bare deadline", context.Background(), context.DeadlineExceeded, true},
+		{"decode", context.Background(), koala.NewError("JsonDecodeErr", koala.ErrDecode, errors.New("unexpected EOF")), true},
+		{"not found", context.Background(), koala.NewError("LocationNotFoundErr", koala.ErrNotFound, nil), false},
+		{"location mismatch", context.Background(), koala.NewError("LocationMismatchErr", koala.ErrLocationMismatch, nil), false},
+		{"caller went away", canceled, UpstreamError(context.Canceled), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBreakerProvider(&countingProvider{err: tc.err}, 3, time.Minute)
+			for i := 0; i < 3; i++ {
+				b.GetFullMenu(tc.ctx)
+			}
+
+			want := BreakerClosed
+			if tc.counts {
+				want = BreakerOpen
+			}
+			if state(b) != want {
+				t.Errorf("breaker is %s after 3 calls, want %s", state(b), want)
+			}
+		})
+	}
+}
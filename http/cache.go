@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// Values for the X-Cache header, they say where the data in a response came from
+const (
+	// CacheHit is a fresh value from the cache
+	CacheHit = "HIT"
+	// CacheMiss means we had to wait on the upstream provider
+	CacheMiss = "MISS"
+	// CacheStale is a value past its ttl, a refresh has been started in the background
+	CacheStale = "STALE"
+	// CacheFallback is the last good value served because the upstream provider failed
+	CacheFallback = "FALLBACK"
+)
+
+// we do a runtime check to ensure our wrapper implements AsyncProvider
+var _ AsyncProvider = &cacheProvider{}
+
+// cacheProvider keeps the last good answer from the wrapped provider in memory
+// Values are shared between requests so callers must copy before changing them,
+// the menu and provider info filters already return copies
+type cacheProvider struct {
+	provider AsyncProvider
+	ttl      time.Duration
+	stale    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	flights flightGroup
+}
+
+// cacheEntry is a value and when we fetched it
+type cacheEntry struct {
+	val     interface{}
+	fetched time.Time
+}
+
+// NewCacheProvider wraps p so its answers are reused for ttl,
+// for stale after that the old value is still served while it is refreshed in the background
+// Concurrent misses share one upstream call and if the upstream fails we serve the last good value no matter how old
+// Wrap the timeout provider rather than the other way around so background refreshes still get a deadline
+// EX: NewCacheProvider(NewTimeoutProvider(p, 10*time.Second), time.Minute, 5*time.Minute)
+func NewCacheProvider(p AsyncProvider, ttl, stale time.Duration) AsyncProvider {
+	return &cacheProvider{
+		provider: p,
+		ttl:      ttl,
+		stale:    stale,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+func (c *cacheProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	v, err := c.get(ctx, "info", func(ctx context.Context) (interface{}, error) {
+		return c.provider.GetProviderInfo(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*koala.ProviderInfo), nil
+}
+
+func (c *cacheProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	v, err := c.get(ctx, "menu", func(ctx context.Context) (interface{}, error) {
+		return c.provider.GetFullMenu(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*koala.Menu), nil
+}
+
+// Unwrap returns the provider this cache wraps
+func (c *cacheProvider) Unwrap() AsyncProvider {
+	return c.provider
+}
+
+// get returns the cached value for key, fetching it when it is missing or too old
+// and records how it was answered on ctx for the X-Cache header
+func (c *cacheProvider) get(ctx context.Context, key string, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+
+	if e != nil {
+		age := time.Since(e.fetched)
+		if age < c.ttl {
+			setCacheStatus(ctx, CacheHit)
+			return e.val, nil
+		}
+
+		if age < c.ttl+c.stale {
+			c.refresh(key, fetch)
+			setCacheStatus(ctx, CacheStale)
+			return e.val, nil
+		}
+	}
+
+	call := c.refresh(key, fetch)
+
+	var err error
+	select {
+	case <-call.done:
+		err = call.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		if e != nil {
+			log.Printf("cache %s: serving last good value after %v", key, err)
+			setCacheStatus(ctx, CacheFallback)
+			return e.val, nil
+		}
+		return nil, err
+	}
+
+	setCacheStatus(ctx, CacheMiss)
+
+	return call.val, nil
+}
+
+// refresh starts fetching key unless a fetch is already running and returns the call to wait on
+// The fetch isn't tied to any one request so a caller giving up doesn't cancel it for everyone else
+func (c *cacheProvider) refresh(key string, fetch func(context.Context) (interface{}, error)) *flightCall {
+	return c.flights.do(key, func() (interface{}, error) {
+		v, err := fetch(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.entries[key] = &cacheEntry{val: v, fetched: time.Now()}
+		c.mu.Unlock()
+
+		return v, nil
+	})
+}
+
+// flightGroup collapses concurrent calls for the same key into one
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a single running call, done is closed once val and err are set
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// do runs fn in its own goroutine unless a call for key is already running,
+// either way it returns the call so the caller can decide how long to wait
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) *flightCall {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c, hit := g.calls[key]; hit {
+		return c
+	}
+
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+
+	go func() {
+		c.val, c.err = fn()
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		close(c.done)
+	}()
+
+	return c
+}
+
+type cacheStatusKey struct{}
+
+// cacheStatus is where a cache provider leaves how it answered for the handler to read
+type cacheStatus struct {
+	mu     sync.Mutex
+	status string
+}
+
+// withCacheStatus returns a context the cache provider can record its status on
+func withCacheStatus(ctx context.Context) (context.Context, *cacheStatus) {
+	s := &cacheStatus{}
+
+	return context.WithValue(ctx, cacheStatusKey{}, s), s
+}
+
+// setCacheStatus records the status if the caller asked for it
+func setCacheStatus(ctx context.Context, status string) {
+	if s, ok := ctx.Value(cacheStatusKey{}).(*cacheStatus); ok {
+		s.mu.Lock()
+		s.status = status
+		s.mu.Unlock()
+	}
+}
+
+// writeHeader sets X-Cache when the provider went through a cache
+func (s *cacheStatus) writeHeader(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status != "" {
+		w.Header().Set("X-Cache", s.status)
+	}
+}
@@ -0,0 +1,223 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// countingProvider counts its calls and answers with a menu named after the call,
+// while block is set every call waits for it to be closed
+type countingProvider struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+	block chan struct{}
+}
+
+func (p *countingProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	m, err := p.GetFullMenu(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ProviderInfo, nil
+}
+
+func (p *countingProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	p.mu.Lock()
+	p.calls++
+	n, err, block := p.calls, p.err, p.block
+	p.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &koala.Menu{ProviderInfo: &koala.ProviderInfo{Name: strconv.Itoa(n)}}, nil
+}
+
+func (p *countingProvider) set(err error, block chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err, p.block = err, block
+}
+
+func (p *countingProvider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.calls
+}
+
+// waitFor polls until the provider has been called n times
+func (p *countingProvider) waitFor(t *testing.T, n int) {
+	t.Helper()
+
+	for start := time.Now(); p.count() < n; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("the provider was called %d times, want %d", p.count(), n)
+		}
+	}
+}
+
+// menu gets the menu through the cache and returns its name and the X-Cache status
+func menu(t *testing.T, c AsyncProvider) (string, string) {
+	t.Helper()
+
+	ctx, status := withCacheStatus(context.Background())
+	m, err := c.GetFullMenu(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m.ProviderInfo.Name, status.status
+}
+
+// age moves when the cached menu was fetched back by d
+func age(c AsyncProvider, d time.Duration) {
+	cp := c.(*cacheProvider)
+	cp.mu.Lock()
+	cp.entries["menu"].fetched = cp.entries["menu"].fetched.Add(-d)
+	cp.mu.Unlock()
+}
+
+func TestCacheHit(t *testing.T) {
+	p := &countingProvider{}
+	c := NewCacheProvider(p, time.Minute, time.Minute)
+
+	if name, status := menu(t, c); name != "1" || status != CacheMiss {
+		t.Errorf("first call got %s %s, want 1 %s", name, status, CacheMiss)
+	}
+	if name, status := menu(t, c); name != "1" || status != CacheHit {
+		t.Errorf("second call got %s %s, want 1 %s", name, status, CacheHit)
+	}
+	if p.count() != 1 {
+		t.Errorf("the provider was called %d times, want 1", p.count())
+	}
+}
+
+func TestCacheCollapsesConcurrentMisses(t *testing.T) {
+	block := make(chan struct{})
+	p := &countingProvider{block: block}
+	c := NewCacheProvider(p, time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	names := make([]string, 10)
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := c.GetFullMenu(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			names[i] = m.ProviderInfo.Name
+		}(i)
+	}
+
+	p.waitFor(t, 1)
+	close(block)
+	wg.Wait()
+
+	if p.count() != 1 {
+		t.Errorf("the provider was called %d times for 10 concurrent misses, want 1", p.count())
+	}
+	for i, name := range names {
+		if name != "1" {
+			t.Errorf("caller %d got menu %q, want 1", i, name)
+		}
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	p := &countingProvider{}
+	c := NewCacheProvider(p, time.Minute, time.Hour)
+	menu(t, c)
+
+	// the refresh is held up but the stale menu is served straight away
+	block := make(chan struct{})
+	p.set(nil, block)
+	age(c, 2*time.Minute)
+
+	if name, status := menu(t, c); name != "1" || status != CacheStale {
+		t.Errorf("got %s %s, want the old menu 1 %s", name, status, CacheStale)
+	}
+	// a second stale read joins the refresh that is already running
+	menu(t, c)
+	p.waitFor(t, 2)
+
+	close(block)
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if name, status := menu(t, c); name == "2" && status == CacheHit {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("the refreshed menu was never served")
+		}
+	}
+
+	if p.count() != 2 {
+		t.Errorf("the provider was called %d times, want 2", p.count())
+	}
+}
+
+func TestCacheFallback(t *testing.T) {
+	p := &countingProvider{}
+	c := NewCacheProvider(p, time.Minute, time.Minute)
+	menu(t, c)
+
+	// past the ttl and the stale window so we have to wait on the upstream, which fails
+	p.set(errors.New("upstream down"), nil)
+	age(c, time.Hour)
+
+	if name, status := menu(t, c); name != "1" || status != CacheFallback {
+		t.Errorf("got %s %s, want the last good menu 1 %s", name, status, CacheFallback)
+	}
+}
+
+func TestCacheErrorWithoutValue(t *testing.T) {
+	down := errors.New("upstream down")
+	c := NewCacheProvider(&countingProvider{err: down}, time.Minute, time.Minute)
+
+	if _, err := c.GetFullMenu(context.Background()); !errors.Is(err, down) {
+		t.Errorf("got %v, want %v", err, down)
+	}
+}
+
+// a caller giving up doesn't cancel the fetch for everyone else, its result is still cached
+func TestCacheCallerGivesUp(t *testing.T) {
+	block := make(chan struct{})
+	p := &countingProvider{block: block}
+	c := NewCacheProvider(p, time.Minute, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetFullMenu(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	close(block)
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, status := menu(t, c); status == CacheHit {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("the fetch the caller gave up on was never cached")
+		}
+	}
+
+	if p.count() != 1 {
+		t.Errorf("the provider was called %d times, want 1", p.count())
+	}
+}
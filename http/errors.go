@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// Error codes in our json error bodies
+const (
+	CodeBadRequest          = "bad_request"
+	CodeNotFound            = "not_found"
+	CodeLocationNotListed   = "location_not_listed"
+	CodeUpstreamUnavailable = "upstream_unavailable"
+	CodeUpstreamTimeout     = "upstream_timeout"
+	CodeDecode              = "upstream_decode"
+	CodeLocationMismatch    = "location_mismatch"
+	CodeCanceled            = "canceled"
+	CodeInternal            = "internal"
+)
+
+// statusClientClosed is the status for a client that went away before we answered, it is nginx's non standard 499
+const statusClientClosed = 499
+
+// ErrNoProvider is a location we have no provider registered for
+var ErrNoProvider = errors.New("RegistryErr: no provider registered for location")
+
+// requestError is a problem with the request itself, its text is shown to the client as is
+type requestError string
+
+func (e requestError) Error() string {
+	return string(e)
+}
+
+// UpstreamError gives an error getting or reading an upstream response its kind,
+// running out of time is koala.ErrUpstreamTimeout and anything else is koala.ErrUpstreamUnavailable
+// An error that already has a kind keeps it
+func UpstreamError(err error) error {
+	var e *koala.Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	if isTimeout(err) {
+		return koala.NewError("", koala.ErrUpstreamTimeout, err)
+	}
+
+	return koala.NewError("", koala.ErrUpstreamUnavailable, err)
+}
+
+// StatusError is the error for an upstream that answered url with a non 2xx status,
+// even a 404 is the upstream being unavailable since it is the url we were configured with that is missing
+func StatusError(status int, url string) error {
+	return koala.NewError("", koala.ErrUpstreamUnavailable, fmt.Errorf("unexpected status %d from %s", status, url))
+}
+
+// errorBody is the json error body every handler writes
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorResponse is the one place an error becomes a status code and a body,
+// loc is the location the request was for so the message can name it
+// A provider we have stopped calling because it keeps failing is a 503, one that ran out of time a 504
+// and an upstream that failed or sent something we couldn't use a 502
+// A location we have no provider for is a plain 404, one whose provider's upstream doesn't list it is also a 404
+// but with its own code since that usually means our config and the upstream disagree
+// A client that hung up is a 499, nobody is there to read it but it keeps it out of the 5xx logs
+// Anything that isn't the clients fault is logged with the request ID since the body only has the friendly message
+func errorResponse(ctx context.Context, loc string, err error) (int, *errorBody) {
+	status, code, msg := http.StatusInternalServerError, CodeInternal, "Something went wrong getting location "+loc+", please try again!"
+
+	var re requestError
+	switch {
+	case errors.As(err, &re):
+		status, code, msg = http.StatusBadRequest, CodeBadRequest, string(re)
+	case errors.Is(err, context.Canceled):
+		status, code, msg = statusClientClosed, CodeCanceled, "Request for location "+loc+" was canceled!"
+	case errors.Is(err, ErrNoProvider):
+		status, code, msg = http.StatusNotFound, CodeNotFound, "No provider found for location "+loc+"!"
+	case errors.Is(err, koala.ErrNotFound):
+		status, code, msg = http.StatusNotFound, CodeLocationNotListed, "Provider for location "+loc+" doesn't list it!"
+	case errors.Is(err, ErrBreakerOpen):
+		status, code, msg = http.StatusServiceUnavailable, CodeUpstreamUnavailable, "Provider for location "+loc+" is unavailable, please try again later!"
+	case errors.Is(err, koala.ErrUpstreamTimeout) || isTimeout(err):
+		status, code, msg = http.StatusGatewayTimeout, CodeUpstreamTimeout, "Timed out waiting for provider for location "+loc+", please try again!"
+	case errors.Is(err, koala.ErrUpstreamUnavailable):
+		status, code, msg = http.StatusBadGateway, CodeUpstreamUnavailable, "Trouble connecting to provider for location "+loc+", please try again!"
+	case errors.Is(err, koala.ErrDecode):
+		status, code, msg = http.StatusBadGateway, CodeDecode, "Provider for location "+loc+" sent a response we could not read!"
+	case errors.Is(err, koala.ErrLocationMismatch):
+		status, code, msg = http.StatusBadGateway, CodeLocationMismatch, "Provider for location "+loc+" answered for a different location!"
+	}
+
+	body := &errorBody{Code: code, Message: msg, RequestID: middleware.GetReqID(ctx)}
+	if status >= 500 || code == CodeLocationNotListed {
+		log.Printf("[%s] location %s: %v", body.RequestID, loc, err)
+	}
+
+	return status, body
+}
+
+// writeError writes the json error body for err
+func writeError(w http.ResponseWriter, r *http.Request, loc string, err error) {
+	status, body := errorResponse(r.Context(), loc, err)
+
+	writeJSON(w, status, body)
+}
+
+// isTimeout reports whether err was caused by a deadline,
+// either our context deadline or a timeout inside the transport
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+func TestErrorResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"bad request", requestError("handoff must be one of pickup"), http.StatusBadRequest, CodeBadRequest},
+		{"no provider", ErrNoProvider, http.StatusNotFound, CodeNotFound},
+		{"not listed", koala.NewError("LocationNotFoundErr", koala.ErrNotFound, nil), http.StatusNotFound, CodeLocationNotListed},
+		{"wrapped not listed", fmt.Errorf("MenuErr: %w", koala.NewError("LocationNotFoundErr", koala.ErrNotFound, nil)), http.StatusNotFound, CodeLocationNotListed},
+		{"breaker open", fmt.Errorf("MenuFetchErr: %w", ErrBreakerOpen), http.StatusServiceUnavailable, CodeUpstreamUnavailable},
+		{"timeout", UpstreamError(context.DeadlineExceeded), http.StatusGatewayTimeout, CodeUpstreamTimeout},
+		{"bare deadline", fmt.Errorf("MenuFetchErr: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeUpstreamTimeout},
+		{"unavailable", fmt.Errorf("LocationFetchErr: %w", StatusError(500, "https://example.com")), http.StatusBadGateway, CodeUpstreamUnavailable},
+		{"decode", koala.NewError("JsonDecodeErr", koala.ErrDecode, errors.New("unexpected EOF")), http.StatusBadGateway, CodeDecode},
+		{"mismatch", koala.NewError("LocationMismatchErr", koala.ErrLocationMismatch, nil), http.StatusBadGateway, CodeLocationMismatch},
+		{"client went away", context.Canceled, statusClientClosed, CodeCanceled},
+		{"client went away mid upstream call", UpstreamError(fmt.Errorf("MenuFetchErr: %w", context.Canceled)), statusClientClosed, CodeCanceled},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := errorResponse(context.Background(), "1", tc.err)
+			if status != tc.status || body.Code != tc.code {
+				t.Errorf("got %d %s, want %d %s", status, body.Code, tc.status, tc.code)
+			}
+		})
+	}
+}
+
+// only failures on our side are logged, a client hanging up isn't one
+func TestErrorResponseLogs(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	errorResponse(context.Background(), "1", context.Canceled)
+	if b.Len() != 0 {
+		t.Errorf("logged %q for a canceled request", b.String())
+	}
+
+	errorResponse(context.Background(), "1", StatusError(500, "https://example.com/menu"))
+	if b.Len() == 0 {
+		t.Error("an upstream failure wasn't logged")
+	}
+}
+
+// an error keeps its kind and its cause through our wrapping
+func TestUpstreamErrorKeepsKind(t *testing.T) {
+	err := UpstreamError(fmt.Errorf("MappingReadErr: %w", koala.NewError("JsonDecodeErr", koala.ErrDecode, context.Canceled)))
+	if !errors.Is(err, koala.ErrDecode) || errors.Is(err, koala.ErrUpstreamUnavailable) || !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want a decode error caused by context.Canceled", err)
+	}
+}
+
+func TestWriteErrorBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/providers/locations/1/menu", nil)
+	middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, "1", StatusError(503, "https://example.com/menu"))
+	})).ServeHTTP(w, r)
+
+	body := &errorBody{}
+	if err := json.NewDecoder(w.Body).Decode(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusBadGateway || body.Code != CodeUpstreamUnavailable || body.Message == "" || body.RequestID == "" {
+		t.Errorf("got %d %+v, want a 502 with a code, message and request ID", w.Code, body)
+	}
+}
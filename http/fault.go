@@ -0,0 +1,160 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// we do a runtime check to ensure our wrapper implements HttpGetter
+var _ HttpGetter = &faultGetter{}
+
+// Fault is one way a GET can go wrong, a fault can do several things at once
+// EX: Fault{URL: "xml-grill", Probability: 0.5, Latency: 2 * time.Second, Status: 503}
+// is a grill that half the time answers slowly with a 503
+type Fault struct {
+	// URL limits the fault to urls containing it, empty is every url
+	URL string
+	// Probability is the chance from 0 to 1 that a matching GET gets the fault, 1 is every time
+	Probability float64
+	// Latency is waited before the GET, it gives up early when ctx is done
+	Latency time.Duration
+	// Err is returned instead of a response EX: a connection refused
+	Err error
+	// Status answers with this status code and its status text as the body instead of calling the upstream
+	Status int
+	// Truncate cuts the body off halfway like a connection that dropped mid response
+	Truncate bool
+	// Malformed replaces the body with a broken document in the same format, json or xml
+	Malformed bool
+}
+
+func (f *Fault) matches(url string) bool {
+	return f.URL == "" || strings.Contains(url, f.URL)
+}
+
+// faultGetter injects faults into the GETs of the getter it wraps
+type faultGetter struct {
+	getter HttpGetter
+	faults []Fault
+	// rand.Rand isn't safe to share between goroutines
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewFaultGetter wraps g so its GETs fail the ways faults describe, it is for testing that providers
+// and the server degrade the way they should when an upstream misbehaves
+// Every fault matching the url is rolled on its own, the ones that hit are applied in the order given
+// EX: NewFaultGetter(client, Fault{Probability: 0.1, Status: 500}) fails one GET in ten
+func NewFaultGetter(g HttpGetter, faults ...Fault) HttpGetter {
+	return &faultGetter{getter: g, faults: faults, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// roll reports whether something with probability p happens this time
+func (f *faultGetter) roll(p float64) bool {
+	if p >= 1 {
+		return true
+	}
+	if p <= 0 {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.rand.Float64() < p
+}
+
+// Get applies the faults that hit in order, a fault that ends the GET EX: an error stops the ones after it
+func (f *faultGetter) Get(ctx context.Context, url string) (*http.Response, error) {
+	var hits []Fault
+	for _, fault := range f.faults {
+		if fault.matches(url) && f.roll(fault.Probability) {
+			hits = append(hits, fault)
+		}
+	}
+
+	var resp *http.Response
+	for _, fault := range hits {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if fault.Err != nil {
+			return nil, fault.Err
+		}
+
+		if fault.Status != 0 && resp == nil {
+			resp = statusResponse(ctx, url, fault.Status)
+		}
+	}
+
+	if resp == nil {
+		var err error
+		if resp, err = f.getter.Get(ctx, url); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fault := range hits {
+		if !fault.Truncate && !fault.Malformed {
+			continue
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if fault.Truncate {
+			b = b[:len(b)/2]
+		}
+		if fault.Malformed {
+			b = malformed(b)
+		}
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		resp.ContentLength = int64(len(b))
+	}
+
+	return resp, nil
+}
+
+// statusResponse is the response we answer with for a status fault
+func statusResponse(ctx context.Context, url string, status int) *http.Response {
+	body := http.StatusText(status)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, body),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// malformed returns a document that starts like b but can't be decoded,
+// it keeps the format so the decoder gets past sniffing the first byte and fails on the structure
+func malformed(b []byte) []byte {
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '<' {
+		return []byte(`<?xml version="1.0"?><restaurant id="1"><menu></restaurant>`)
+	}
+
+	return []byte(`{"objects": [{"id": "1",]}`)
+}
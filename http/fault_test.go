@@ -0,0 +1,303 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+const location = `{"id": "1", "name": "Koala Test Kitchen", "timezone": "America/New_York"}`
+
+// locationUpstream serves one json location at /location/<id>, it is location with the id from the path
+func locationUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.Replace(location, `"id": "1"`, `"id": "`+path.Base(r.URL.Path)+`"`, 1)))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// jsonProvider is the smallest provider we can put behind the server, it fetches a single json location
+type jsonProvider struct {
+	client HttpGetter
+	url    string
+}
+
+func (p *jsonProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	resp, err := p.client.Get(ctx, p.url)
+	if err != nil {
+		return nil, UpstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, StatusError(resp.StatusCode, p.url)
+	}
+
+	info := &koala.ProviderInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, koala.NewError("JsonDecodeErr", koala.ErrDecode, err)
+	}
+
+	return info, nil
+}
+
+func (p *jsonProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	info, err := p.GetProviderInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &koala.Menu{ProviderInfo: info, MenuItems: []*koala.MenuItem{}}, nil
+}
+
+func read(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestFaultGetter(t *testing.T) {
+	srv := locationUpstream(t)
+	refused := errors.New("connection refused")
+
+	cases := []struct {
+		name  string
+		fault Fault
+		check func(t *testing.T, resp *http.Response, err error)
+	}{
+		{"status", Fault{Probability: 1, Status: 503}, func(t *testing.T, resp *http.Response, err error) {
+			if err != nil || resp.StatusCode != 503 || read(t, resp) != "Service Unavailable" {
+				t.Errorf("got %v %v, want a 503", resp, err)
+			}
+		}},
+		{"error", Fault{Probability: 1, Err: refused}, func(t *testing.T, resp *http.Response, err error) {
+			if !errors.Is(err, refused) {
+				t.Errorf("got %v, want %v", err, refused)
+			}
+		}},
+		{"truncate", Fault{Probability: 1, Truncate: true}, func(t *testing.T, resp *http.Response, err error) {
+			if body := read(t, resp); body != location[:len(location)/2] {
+				t.Errorf("got %q, want the first half of the location", body)
+			}
+		}},
+		{"malformed", Fault{Probability: 1, Malformed: true}, func(t *testing.T, resp *http.Response, err error) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(read(t, resp)), &v); err == nil {
+				t.Error("the malformed body decoded")
+			}
+		}},
+		{"other url", Fault{URL: "/menu", Probability: 1, Status: 500}, func(t *testing.T, resp *http.Response, err error) {
+			if err != nil || read(t, resp) != location {
+				t.Errorf("a fault for another url changed the response %v", err)
+			}
+		}},
+		{"never", Fault{Probability: 0, Status: 500}, func(t *testing.T, resp *http.Response, err error) {
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Errorf("a fault with no probability hit %v", err)
+			}
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := NewFaultGetter(NewClient(), tc.fault).Get(context.Background(), srv.URL+"/location/1")
+			tc.check(t, resp, err)
+		})
+	}
+}
+
+func TestFaultGetterProbability(t *testing.T) {
+	srv := locationUpstream(t)
+	g := NewFaultGetter(NewClient(), Fault{Probability: 0.5, Err: errors.New("refused")})
+
+	failed := 0
+	for i := 0; i < 400; i++ {
+		resp, err := g.Get(context.Background(), srv.URL)
+		if err != nil {
+			failed++
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	// far enough from 200 either way that this never flakes
+	if failed < 120 || failed > 280 {
+		t.Errorf("%d of 400 failed with a probability of 0.5", failed)
+	}
+}
+
+func TestFaultGetterLatency(t *testing.T) {
+	srv := locationUpstream(t)
+	g := NewFaultGetter(NewClient(), Fault{Probability: 1, Latency: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := g.Get(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// serve sends a GET through the servers router and returns the status code
+func serve(s *Server, path string) int {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return w.Code
+}
+
+// faultyServer is a server with a provider for location 1 behind the given fault
+// and healthy ones for locations 2 and 3
+func faultyServer(t *testing.T, wrap func(AsyncProvider) AsyncProvider, f Fault) *Server {
+	t.Helper()
+
+	srv := locationUpstream(t)
+	reg := NewRegistry()
+	reg.Register("1", wrap(&jsonProvider{client: NewFaultGetter(NewClient(), f), url: srv.URL + "/location/1"}))
+	reg.Register("2", &jsonProvider{client: NewClient(), url: srv.URL + "/location/2"})
+	reg.Register("3", &jsonProvider{client: NewClient(), url: srv.URL + "/location/3"})
+
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	return s
+}
+
+func TestServerDegrades(t *testing.T) {
+	timeout := func(p AsyncProvider) AsyncProvider { return NewTimeoutProvider(p, 20*time.Millisecond) }
+
+	cases := []struct {
+		name  string
+		fault Fault
+		want  int
+	}{
+		{"upstream error", Fault{Probability: 1, Status: 500}, http.StatusBadGateway},
+		{"connection refused", Fault{Probability: 1, Err: errors.New("connection refused")}, http.StatusBadGateway},
+		{"truncated body", Fault{Probability: 1, Truncate: true}, http.StatusBadGateway},
+		{"malformed body", Fault{Probability: 1, Malformed: true}, http.StatusBadGateway},
+		{"slow upstream", Fault{Probability: 1, Latency: time.Minute}, http.StatusGatewayTimeout},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := faultyServer(t, timeout, tc.fault)
+
+			if got := serve(s, "/providers/locations/1/"); got != tc.want {
+				t.Errorf("location got %d, want %d", got, tc.want)
+			}
+			if got := serve(s, "/providers/locations/1/menu"); got != tc.want {
+				t.Errorf("menu got %d, want %d", got, tc.want)
+			}
+			// the healthy location is still served on its own and in the aggregate
+			if got := serve(s, "/providers/locations/2/"); got != http.StatusOK {
+				t.Errorf("healthy location got %d, want 200", got)
+			}
+
+			// the aggregates have every healthy location in registry order and an error naming the failed one
+			for _, path := range []string{"/providers/locations", "/providers/menus"} {
+				status, res := aggregate(t, s, path)
+				if status != http.StatusOK {
+					t.Errorf("%s got %d, want 200 with one failed location", path, status)
+				}
+				if got := res.IDs(); !reflect.DeepEqual(got, []string{"2", "3"}) {
+					t.Errorf("%s got locations %v, want 2 and 3", path, got)
+				}
+				if len(res.Errors) != 1 || res.Errors[0].LocationID != "1" || res.Errors[0].Status != tc.want || res.Errors[0].Code == "" || res.Errors[0].Message == "" {
+					t.Errorf("%s got errors %+v, want one for location 1 with a %d", path, res.Errors, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// aggregateBody is the body of either aggregate endpoint
+type aggregateBody struct {
+	Locations []*koala.ProviderInfo `json:"locations"`
+	Menus     []*koala.Menu         `json:"menus"`
+	Errors    []struct {
+		LocationID string `json:"location_id"`
+		Status     int    `json:"status"`
+		Code       string `json:"code"`
+		Message    string `json:"message"`
+	} `json:"errors"`
+}
+
+// IDs lists the location IDs in the body in the order they came back
+func (b *aggregateBody) IDs() []string {
+	IDs := []string{}
+	for _, info := range b.Locations {
+		IDs = append(IDs, info.ID)
+	}
+	for _, m := range b.Menus {
+		IDs = append(IDs, m.ProviderInfo.ID)
+	}
+
+	return IDs
+}
+
+// aggregate GETs one of the aggregate endpoints and decodes its body
+func aggregate(t *testing.T, s *Server, path string) (int, *aggregateBody) {
+	t.Helper()
+
+	status, body := send(s, http.MethodGet, path, "")
+	res := &aggregateBody{}
+	if err := json.Unmarshal([]byte(body), res); err != nil {
+		t.Fatalf("%s sent %s: %v", path, body, err)
+	}
+
+	return status, res
+}
+
+func TestServerDegradesBreaker(t *testing.T) {
+	breaker := func(p AsyncProvider) AsyncProvider { return NewBreakerProvider(p, 2, time.Minute) }
+	s := faultyServer(t, breaker, Fault{Probability: 1, Status: 500})
+
+	for i := 0; i < 2; i++ {
+		if got := serve(s, "/providers/locations/1/"); got != http.StatusBadGateway {
+			t.Fatalf("call %d got %d, want 502 before the breaker opens", i, got)
+		}
+	}
+
+	if got := serve(s, "/providers/locations/1/"); got != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503 once the breaker is open", got)
+	}
+}
+
+func TestServerDegradesEverywhere(t *testing.T) {
+	srv := locationUpstream(t)
+	g := NewFaultGetter(NewClient(), Fault{Probability: 1, Status: 502})
+
+	reg := NewRegistry()
+	reg.Register("1", &jsonProvider{client: g, url: srv.URL + "/location/1"})
+	reg.Register("2", &jsonProvider{client: g, url: srv.URL + "/location/2"})
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	if got := serve(s, "/providers/locations"); got != http.StatusBadGateway {
+		t.Errorf("got %d, want 502 when every location failed", got)
+	}
+	if got := serve(s, "/providers/menus"); got != http.StatusBadGateway {
+		t.Errorf("got %d, want 502 when every menu failed", got)
+	}
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNotRecorded is returned by a replayer for a url it has no fixture for
+var ErrNotRecorded = errors.New("no recorded response")
+
+// we do a runtime check to ensure our recorder and replayer implement HttpGetter
+var (
+	_ HttpGetter = &recorder{}
+	_ HttpGetter = &replayer{}
+)
+
+// fixture is one recorded response, kept as json so it can be read and edited by hand
+type fixture struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// a body that isn't valid utf8 EX: an image is kept as base64 instead so no bytes are lost
+	BodyBase64 []byte `json:"body_base64,omitempty"`
+}
+
+// fixtureName is the file a urls response is kept in, the readable part is the host and path
+// and the hash keeps urls that only differ in their query apart
+// EX: https://api.example.com/v2/menu?location=1 is api.example.com-v2-menu-<hash>.json
+func fixtureName(rawURL string) string {
+	slug := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		slug = u.Host + u.Path
+	}
+
+	slug = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '-'
+	}, slug)
+	slug = strings.Trim(slug, "-.")
+	if len(slug) > 64 {
+		slug = slug[:64]
+	}
+
+	sum := sha256.Sum256([]byte(rawURL))
+
+	return slug + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// recorder passes every GET through to the getter it wraps and writes the response to dir
+type recorder struct {
+	getter HttpGetter
+	dir    string
+	// two requests for the same url would otherwise write the same file at once
+	mu sync.Mutex
+}
+
+// NewRecorder wraps g so every response it gets is also saved as a fixture in dir,
+// the status, headers and body are kept so a replayer can serve them back later
+// EX: NewRecorder(NewClient(), "./fixtures") while hitting the real upstreams once
+// Network errors aren't recorded, a url that is fetched again is recorded over
+func NewRecorder(g HttpGetter, dir string) HttpGetter {
+	return &recorder{getter: g, dir: dir}
+}
+
+// Get fetches the url, records the response and returns it with a body that can still be read
+func (r *recorder) Get(ctx context.Context, url string) (*http.Response, error) {
+	resp, err := r.getter.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("RecordErr: Could not read %s %w", url, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	f := &fixture{URL: url, Status: resp.StatusCode, Header: resp.Header}
+	if utf8.Valid(b) {
+		f.Body = string(b)
+	} else {
+		f.BodyBase64 = b
+	}
+
+	if err := r.write(f); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (r *recorder) write(f *fixture) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("RecordErr: Could not encode %s %w", f.URL, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("RecordErr: %w", err)
+	}
+
+	path := filepath.Join(r.dir, fixtureName(f.URL))
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("RecordErr: Could not save %s %w", f.URL, err)
+	}
+
+	return nil
+}
+
+// replayer serves responses a recorder saved, it never touches the network
+type replayer struct {
+	dir string
+}
+
+// NewReplayer returns a getter that answers every GET from the fixtures in dir,
+// a url without a fixture is an ErrNotRecorded error rather than a silent network call
+// EX: NewReplayer("./fixtures") in a test that has to run offline
+func NewReplayer(dir string) HttpGetter {
+	return &replayer{dir: dir}
+}
+
+// Get serves the recorded response for url, every call gets its own copy of the body
+func (r *replayer) Get(ctx context.Context, url string) (*http.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(r.dir, fixtureName(url))
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("ReplayErr: %s was never recorded, expected %s %w", url, path, ErrNotRecorded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReplayErr: %w", err)
+	}
+
+	f := &fixture{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("ReplayErr: Could not decode %s %w", path, err)
+	}
+	// the hash makes a mixup unlikely but a hand edited fixture could still point somewhere else
+	if f.URL != url {
+		return nil, fmt.Errorf("ReplayErr: %s holds %s not %s %w", path, f.URL, url, ErrNotRecorded)
+	}
+
+	body := []byte(f.Body)
+	if f.BodyBase64 != nil {
+		body = f.BodyBase64
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header := f.Header
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// upstream serves a json menu, a binary body and a 503 so every kind of response gets recorded
+func upstream(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/menu", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"items":[]}`))
+	})
+	mux.HandleFunc("/logo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0x00, 0xfe})
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func get(t *testing.T, g HttpGetter, url string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := g.Get(context.Background(), url)
+	if err != nil {
+		t.Fatalf("Get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(b)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := upstream(t)
+	dir := t.TempDir()
+
+	rec := NewRecorder(NewClient(), dir)
+	want := map[string]string{}
+	for _, path := range []string{"/menu", "/logo", "/down", "/menu?page=2"} {
+		_, body := get(t, rec, srv.URL+path)
+		want[path] = body
+	}
+
+	// nothing is served from the network from here on
+	srv.Close()
+
+	rep := NewReplayer(dir)
+	for path, body := range want {
+		resp, got := get(t, rep, srv.URL+path)
+		if got != body {
+			t.Errorf("%s replayed body %q, recorded %q", path, got, body)
+		}
+		if resp.Request == nil || resp.Request.URL.String() != srv.URL+path {
+			t.Errorf("%s replayed without its request", path)
+		}
+	}
+
+	resp, _ := get(t, rep, srv.URL+"/menu")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"v1"` || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("/menu replayed status %d and headers %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, _ = get(t, rep, srv.URL+"/down")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/down replayed status %d, recorded 503", resp.StatusCode)
+	}
+}
+
+func TestReplayUnrecorded(t *testing.T) {
+	_, err := NewReplayer(t.TempDir()).Get(context.Background(), "https://example.com/menu")
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Fatalf("got %v, want ErrNotRecorded", err)
+	}
+}
+
+func TestReplayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewReplayer(t.TempDir()).Get(ctx, "https://example.com/menu"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	a := fixtureName("https://api.example.com/v2/menu?location=1")
+	b := fixtureName("https://api.example.com/v2/menu?location=2")
+	if a == b {
+		t.Fatalf("urls that differ in their query share the fixture %s", a)
+	}
+	if want := "api.example.com-v2-menu-"; a[:len(want)] != want {
+		t.Errorf("got %s, want it to start with %s", a, want)
+	}
+}
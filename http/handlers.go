@@ -1,103 +1,348 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	koala "github.com/ko1eda/apiaggregator"
 )
 
 // Handler
+// Looks up the provider for the location in our registry and returns its info
+// along with whether the store is open at ?at=<RFC3339> or right now
 func (s *Server) handleGetProviderInfo() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+	// the status fields sit next to the provider info in our json
+	type response struct {
+		*koala.ProviderInfo
+		*koala.StoreStatus
+	}
 
+	return func(w http.ResponseWriter, r *http.Request) {
 		loc := chi.URLParam(r, "id")
 
-		// zero value for our datatype
-		var pi *koala.ProviderInfo
-		switch loc {
-		// grill
-		case "1":
-			val, err := s.XmlGrillProvider.GetProviderInfo()
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("{\"error\":\"Trouble connecting to grill provider, please try again!\"}"))
-				return
-			}
-			pi = val
-		// eatary
-		case "2":
-			val, err := s.JsonEateryProvider.GetProviderInfo()
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("{\"error\":\"Trouble connecting to eatery provider, please try again!\"}"))
-				return
-			}
-			pi = val
+		p, hit := s.Providers().Lookup(loc)
+		if !hit {
+			writeError(w, r, loc, ErrNoProvider)
+			return
+		}
+
+		at, err := timeParam(r, "at")
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+		handoff, err := handoffParam(r)
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+		if at == nil {
+			now := time.Now()
+			at = &now
+		}
 
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("{\"error\":\"No items found for this location!\"}"))
+		ctx, cache := withCacheStatus(r.Context())
+		pi, err := p.GetProviderInfo(ctx)
+		cache.writeHeader(w)
+		if err != nil {
+			writeError(w, r, loc, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		// with a handoff mode the hours and status are for that mode only
+		if handoff != "" {
+			pi = pi.ForHandoff(handoff)
+		}
+
+		// a bad timezone from upstream shouldn't hide the rest of the info, we just leave the status out
+		status, err := pi.StatusAt(*at)
+		if err != nil {
+			log.Printf("location %s: %v", loc, err)
+		}
 
-		// encode the result
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		enc.Encode(pi)
+		writeJSON(w, http.StatusOK, &response{ProviderInfo: pi, StoreStatus: status})
 	}
 }
 
 // This gets the full menu and returns the json
-// If it hits a location id we don't know it returns an error
-// For more routes we could add a map that holds our ID's and
-// the corresponding function and load that into a config at runtime with a flag
+// If it hits a location id we don't have registered it returns a 404
 func (s *Server) handleGetFullMenu() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
+		loc := chi.URLParam(r, "id")
 
+		p, hit := s.Providers().Lookup(loc)
+		if !hit {
+			writeError(w, r, loc, ErrNoProvider)
+			return
+		}
+
+		// optional instant to filter the menu down to what can be ordered then
+		at, err := timeParam(r, "available_at")
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+		// optional handoff mode to hide items and hours that don't apply to it
+		handoff, err := handoffParam(r)
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+
+		// the provider fetches on the request context so a client that hangs up stops its upstream calls
+		ctx, cache := withCacheStatus(r.Context())
+		menu, err := p.GetFullMenu(ctx)
+		cache.writeHeader(w)
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+
+		if at != nil {
+			// item times are on the stores wall clock so read the instant there
+			if menu.ProviderInfo != nil {
+				if loc, err := menu.ProviderInfo.Location(); err == nil {
+					*at = at.In(loc)
+				}
+			}
+			menu = menu.AvailableAt(*at)
+		}
+
+		if handoff != "" {
+			menu = menu.ForHandoff(handoff)
+		}
+
+		writeJSON(w, http.StatusOK, menu)
+	}
+}
+
+// maxOrderBytes is the largest order body we read, a real cart is a tiny fraction of this
+const maxOrderBytes = 1 << 20
+
+// Validates a cart against the locations menu and prices it
+// An order we can't price is a 422 with the itemized errors in the quote
+func (s *Server) handleQuote() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		loc := chi.URLParam(r, "id")
 
-		var menu *koala.Menu
-		switch loc {
-		case "1":
-			// this runs a goroutine  under the scenes usually I would
-			// pull this aysnc functionality up to the handler
-			// put to keep it short I have left behavior inside the provider
-			val, err := s.XmlGrillProvider.GetFullMenu()
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("{\"error\":\"Trouble connecting to grill provider, please try again!\"}"))
+		// an unknown location is a 404 whatever the body is
+		p, hit := s.Providers().Lookup(loc)
+		if !hit {
+			writeError(w, r, loc, ErrNoProvider)
+			return
+		}
+
+		order := &koala.Order{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOrderBytes)).Decode(order); err != nil {
+			// go 1.16 has no typed error for this, MaxBytesReader only gives us its message
+			if strings.Contains(err.Error(), "request body too large") {
+				writeError(w, r, loc, requestError(fmt.Sprintf("The order is too large, it can be at most %d bytes!", maxOrderBytes)))
 				return
 			}
-			menu = val
-		case "2":
-			// this runs a goroutine  under the scenes usually I would
-			// pull this aysnc functionality up to the handler
-			// put to keep it short I have left behavior inside the provider
-			val, err := s.JsonEateryProvider.GetFullMenu()
+			writeError(w, r, loc, requestError("Could not read the order, please check the request body!"))
+			return
+		}
+
+		ctx, cache := withCacheStatus(r.Context())
+		menu, err := p.GetFullMenu(ctx)
+		cache.writeHeader(w)
+		if err != nil {
+			writeError(w, r, loc, err)
+			return
+		}
+
+		quote := menu.Quote(order)
+		if !quote.Valid {
+			writeJSON(w, http.StatusUnprocessableEntity, quote)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, quote)
+	}
+}
+
+// Returns the provider info for every registered location
+// a failing provider is reported in the errors list instead of failing the whole response
+func (s *Server) handleGetAllProviderInfo() http.HandlerFunc {
+	type response struct {
+		Locations []*koala.ProviderInfo `json:"locations"`
+		Errors    []*providerError      `json:"errors,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := s.fanOut(r.Context(), func(ctx context.Context, p AsyncProvider) (interface{}, error) {
+			return p.GetProviderInfo(ctx)
+		})
+
+		res := &response{Locations: []*koala.ProviderInfo{}}
+		for _, v := range results {
+			if v.err != nil {
+				res.Errors = append(res.Errors, v.err)
+				continue
+			}
+			res.Locations = append(res.Locations, v.val.(*koala.ProviderInfo))
+		}
+
+		writeJSON(w, aggregateStatus(len(results), len(res.Errors)), res)
+	}
+}
+
+// Returns the full menu for every registered location
+// a failing provider is reported in the errors list instead of failing the whole response
+func (s *Server) handleGetAllMenus() http.HandlerFunc {
+	type response struct {
+		Menus  []*koala.Menu    `json:"menus"`
+		Errors []*providerError `json:"errors,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := s.fanOut(r.Context(), func(ctx context.Context, p AsyncProvider) (interface{}, error) {
+			return p.GetFullMenu(ctx)
+		})
+
+		res := &response{Menus: []*koala.Menu{}}
+		for _, v := range results {
+			if v.err != nil {
+				res.Errors = append(res.Errors, v.err)
+				continue
+			}
+			res.Menus = append(res.Menus, v.val.(*koala.Menu))
+		}
+
+		writeJSON(w, aggregateStatus(len(results), len(res.Errors)), res)
+	}
+}
+
+// Reports the health of every registered provider, for now that is the state of its circuit breaker
+func (s *Server) handleStatus() http.HandlerFunc {
+	type providerStatus struct {
+		LocationID string         `json:"location_id"`
+		Breaker    *BreakerStatus `json:"breaker,omitempty"`
+	}
+
+	type response struct {
+		Providers []*providerStatus `json:"providers"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		reg := s.Providers()
+		res := &response{Providers: []*providerStatus{}}
+		for _, id := range reg.IDs() {
+			p, hit := reg.Lookup(id)
+			if !hit {
+				continue
+			}
+			res.Providers = append(res.Providers, &providerStatus{LocationID: id, Breaker: breakerStatus(p)})
+		}
+
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// providerError is how we report a single failed provider in an aggregate response,
+// it is the same body a request for just that location would get along with its ID and status
+type providerError struct {
+	LocationID string `json:"location_id"`
+	Status     int    `json:"status"`
+	*errorBody
+}
+
+// fanResult holds one providers answer when we fan out to all of them
+type fanResult struct {
+	val interface{}
+	err *providerError
+}
+
+// fanOut calls fn for every registered provider concurrently and waits for all of them,
+// results come back in the same order as the registry IDs so responses are stable
+func (s *Server) fanOut(ctx context.Context, fn func(context.Context, AsyncProvider) (interface{}, error)) []*fanResult {
+	// one snapshot for the whole fan out so a reload halfway through can't mix two configs
+	reg := s.Providers()
+	ids := reg.IDs()
+	results := make([]*fanResult, len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		p, hit := reg.Lookup(id)
+		if !hit {
+			// removed between listing and lookup, treat it like any other missing location
+			status, body := errorResponse(ctx, id, ErrNoProvider)
+			results[i] = &fanResult{err: &providerError{LocationID: id, Status: status, errorBody: body}}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, id string, p AsyncProvider) {
+			defer wg.Done()
+
+			val, err := fn(ctx, p)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("{\"error\":\"Trouble connecting to eatery provider, please try again!\"}"))
+				status, body := errorResponse(ctx, id, err)
+				results[i] = &fanResult{err: &providerError{LocationID: id, Status: status, errorBody: body}}
 				return
 			}
-			menu = val
+			results[i] = &fanResult{val: val}
+		}(i, id, p)
+	}
 
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("{\"error\":\"No items found for this location!\"}"))
-			return
-		}
+	wg.Wait()
 
-		w.WriteHeader(http.StatusOK)
+	return results
+}
 
-		// encode the result
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		enc.Encode(menu)
+// aggregateStatus is a 200 as long as one provider answered,
+// if every provider failed there is nothing useful to return so we report a bad gateway
+func aggregateStatus(total, failed int) int {
+	if total > 0 && total == failed {
+		return http.StatusBadGateway
 	}
+
+	return http.StatusOK
+}
+
+// timeParam reads an optional RFC3339 query parameter, it is nil when the parameter is left out
+func timeParam(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, requestError(name + " must be an RFC3339 time EX: 2020-11-26T12:00:00-05:00")
+	}
+
+	return &t, nil
+}
+
+// handoffParam reads the optional ?handoff= query parameter, it is empty when left out
+func handoffParam(r *http.Request) (koala.HandoffMode, error) {
+	v := r.URL.Query().Get("handoff")
+	if v == "" {
+		return "", nil
+	}
+
+	m, err := koala.ParseHandoffMode(v)
+	if err != nil {
+		return "", requestError("handoff must be one of pickup, curbside, delivery, drivethru or dinein")
+	}
+
+	return m, nil
+}
+
+// writeJSON writes the status code and encodes v as indented json
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
 }
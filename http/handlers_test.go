@@ -0,0 +1,147 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// menuProvider serves a fixed menu with an item whose sauce group allows a single pick
+// and a shake that can only be had dine in
+type menuProvider struct{}
+
+func (p *menuProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	return &koala.ProviderInfo{ID: "1", Name: "Koala Test Kitchen"}, nil
+}
+
+func (p *menuProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	info, _ := p.GetProviderInfo(ctx)
+
+	return &koala.Menu{ProviderInfo: info, MenuItems: []*koala.MenuItem{{
+		ID:         "wings",
+		Name:       "Wings",
+		Variations: []*koala.Variation{{ID: "wings-reg", Price: koala.NewMoney(999, "USD")}},
+		Modifiers: []*koala.Modifier{{
+			ID:    "sauce",
+			Name:  "Sauce",
+			Rules: koala.SelectionRules{SelectionType: koala.SelectionSingle, Mandatory: true, MinSelects: 1, MaxSelects: 1},
+			Options: []*koala.ModifierOption{
+				{ID: "bbq", Name: "BBQ", Cost: koala.NewMoney(0, "USD")},
+				{ID: "truffle", Name: "Truffle", Cost: koala.NewMoney(150, "USD")},
+			},
+		}},
+	}, {
+		ID:           "shake",
+		Name:         "Shake",
+		Variations:   []*koala.Variation{{ID: "shake-reg", Price: koala.NewMoney(499, "USD")}},
+		HandoffModes: koala.HandoffModes{koala.HandoffDineIn},
+	}}}, nil
+}
+
+// send sends a request through the servers router and returns the status code and body
+func send(s *Server, method, path, body string) (int, string) {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	return w.Code, w.Body.String()
+}
+
+// post sends a POST with the body through the servers router and returns the status code and body
+func post(s *Server, path, body string) (int, string) {
+	return send(s, http.MethodPost, path, body)
+}
+
+func TestHandleQuote(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register("1", &menuProvider{})
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	cases := []struct {
+		name   string
+		loc    string
+		body   string
+		status int
+		// a piece of the body we expect
+		want string
+	}{
+		{"valid", "1", `{"items": [{"item_id": "wings", "options": [{"option_id": "truffle"}]}]}`, http.StatusOK, `"amount": 1149`},
+		{"too few selected", "1", `{"items": [{"item_id": "wings"}]}`, http.StatusUnprocessableEntity, koala.QuoteTooFewSelected},
+		{"too many selected", "1", `{"items": [{"item_id": "wings", "options": [{"option_id": "bbq"}, {"option_id": "truffle"}]}]}`, http.StatusUnprocessableEntity, koala.QuoteTooManySelected},
+		{"unknown option", "1", `{"items": [{"item_id": "wings", "options": [{"option_id": "ranch"}]}]}`, http.StatusUnprocessableEntity, koala.QuoteUnknownOption},
+		{"bad body", "1", `{"items": [`, http.StatusBadRequest, CodeBadRequest},
+		{"body too large", "1", `{"items": [], "note": "` + strings.Repeat("a", maxOrderBytes) + `"}`, http.StatusBadRequest, "The order is too large"},
+		{"option repeated", "1", `{"items": [{"item_id": "wings", "options": [{"option_id": "bbq"}, {"option_id": "bbq"}]}]}`, http.StatusUnprocessableEntity, koala.QuoteDuplicateOption},
+		{"unknown location", "2", `{"items": [{"item_id": "wings"}]}`, http.StatusNotFound, CodeNotFound},
+		// the location is checked before the body
+		{"unknown location with a bad body", "2", `{"items": [`, http.StatusNotFound, CodeNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := post(s, "/providers/locations/"+tc.loc+"/quote", tc.body)
+			if status != tc.status || !strings.Contains(body, tc.want) {
+				t.Errorf("got %d %s, want %d with %s", status, body, tc.status, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandoffParam(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register("1", &menuProvider{})
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		want   []string
+		// a piece of the body we don't expect
+		hidden string
+	}{
+		{"whole menu", "/providers/locations/1/menu", http.StatusOK, []string{`"wings"`, `"shake"`}, ""},
+		{"pickup menu", "/providers/locations/1/menu?handoff=pickup", http.StatusOK, []string{`"wings"`}, `"shake"`},
+		{"dine in menu", "/providers/locations/1/menu?handoff=DineIn", http.StatusOK, []string{`"wings"`, `"shake"`}, ""},
+		{"unknown mode for the menu", "/providers/locations/1/menu?handoff=teleport", http.StatusBadRequest, []string{CodeBadRequest, "handoff must be one of"}, ""},
+		{"info for a mode", "/providers/locations/1?handoff=delivery", http.StatusOK, []string{"Koala Test Kitchen"}, ""},
+		{"unknown mode for the info", "/providers/locations/1?handoff=teleport", http.StatusBadRequest, []string{CodeBadRequest, "handoff must be one of"}, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := send(s, http.MethodGet, tc.path, "")
+			if status != tc.status {
+				t.Errorf("got %d %s, want %d", status, body, tc.status)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s is missing %s", body, want)
+				}
+			}
+			if tc.hidden != "" && strings.Contains(body, tc.hidden) {
+				t.Errorf("%s has %s, want it filtered out", body, tc.hidden)
+			}
+		})
+	}
+}
+
+// a location we have no provider for is a 404 on every endpoint for one location
+func TestUnknownLocation(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register("1", &menuProvider{})
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	for _, path := range []string{"/providers/locations/2", "/providers/locations/2/menu"} {
+		status, body := send(s, http.MethodGet, path, "")
+		if status != http.StatusNotFound || !strings.Contains(body, CodeNotFound) || !strings.Contains(body, "No provider found for location 2!") {
+			t.Errorf("%s got %d %s, want a 404 not_found", path, status, body)
+		}
+	}
+}
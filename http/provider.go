@@ -1,12 +1,65 @@
 package http
 
 import (
+	"context"
+	"time"
+
 	koala "github.com/ko1eda/apiaggregator"
 )
 
 // This service allows us to abstract away all our lower level json conversions
 // All of these items will eventually make external api calls, so they will be blocking.
+// Implementations must stop work and return once ctx is done.
 type AsyncProvider interface {
-	GetProviderInfo() (*koala.ProviderInfo, error)
-	GetFullMenu() (*koala.Menu, error)
+	GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error)
+	GetFullMenu(ctx context.Context) (*koala.Menu, error)
+}
+
+// MultiLocationProvider is a provider whose upstream serves many locations from the same documents,
+// Locations lists every location ID the upstream has and ForLocation returns a provider for one of them
+// so a single config entry can serve them all, the locations share the upstreams menu
+type MultiLocationProvider interface {
+	AsyncProvider
+	Locations(ctx context.Context) ([]string, error)
+	ForLocation(ID string) AsyncProvider
+}
+
+// Our wrappers around a provider return the provider they wrap with Unwrap,
+// this lets us walk down to a specific wrapper EX: the breaker for the status endpoint
+type unwrapper interface {
+	Unwrap() AsyncProvider
+}
+
+// we do a runtime check to ensure our wrapper implements AsyncProvider
+var _ AsyncProvider = &timeoutProvider{}
+
+// timeoutProvider gives every call to the wrapped provider its own deadline
+type timeoutProvider struct {
+	provider AsyncProvider
+	timeout  time.Duration
+}
+
+// NewTimeoutProvider wraps p so each call is cancelled after d,
+// the deadline is in addition to any deadline already on the callers context
+func NewTimeoutProvider(p AsyncProvider, d time.Duration) AsyncProvider {
+	return &timeoutProvider{provider: p, timeout: d}
+}
+
+func (t *timeoutProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	return t.provider.GetProviderInfo(ctx)
+}
+
+func (t *timeoutProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	return t.provider.GetFullMenu(ctx)
+}
+
+// Unwrap returns the provider this wrapper gives deadlines to
+func (t *timeoutProvider) Unwrap() AsyncProvider {
+	return t.provider
 }
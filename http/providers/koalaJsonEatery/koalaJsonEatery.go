@@ -1,9 +1,9 @@
 package koalaJsonEatery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 
 	koala "github.com/ko1eda/apiaggregator"
@@ -11,24 +11,32 @@ import (
 )
 
 // we do a runtime check to ensure our item implenets our AsyncProviderService
-var _ http.AsyncProvider = &KoalaJsonEatery{}
+// and can serve every location in the eaterys location list
+var _ http.MultiLocationProvider = &KoalaJsonEatery{}
 
-// We could add a config and pass our endpoints and add them to a map on our provider
-// We are using files for now
+// Default upstream endpoints, these point at our golden files
+// so they need a client that can serve file:// urls (see http.WithFileRoot)
 var (
-	menuUrl     = "./goldenfiles/json-eatery-menu.json"
-	locationUrl = "./goldenfiles/json-eatery-location.json"
+	menuUrl     = "file:///json-eatery-menu.json"
+	locationUrl = "file:///json-eatery-location.json"
 )
 
 // Represents our JsonEatery data provider
 type KoalaJsonEatery struct {
-	LocationID string
-	client     http.HttpGetter
+	LocationID  string
+	MenuURL     string
+	LocationURL string
+	client      http.HttpGetter
 }
 
 // Return a new jsonEatery with sensible defaults and variadic modifier params
 func NewProvider(c http.HttpGetter, opts ...func(*KoalaJsonEatery)) *KoalaJsonEatery {
-	k := &KoalaJsonEatery{client: c, LocationID: "2"}
+	k := &KoalaJsonEatery{
+		client:      c,
+		LocationID:  "2",
+		MenuURL:     menuUrl,
+		LocationURL: locationUrl,
+	}
 	for _, opt := range opts {
 		opt(k)
 	}
@@ -45,6 +53,46 @@ func WithLocationID(ID string) func(*KoalaJsonEatery) {
 	}
 }
 
+// WithMenuURL sets the upstream endpoint we fetch the menu catalog from
+func WithMenuURL(url string) func(*KoalaJsonEatery) {
+	return func(k *KoalaJsonEatery) {
+		k.MenuURL = url
+	}
+}
+
+// WithLocationURL sets the upstream endpoint we fetch the location list from
+func WithLocationURL(url string) func(*KoalaJsonEatery) {
+	return func(k *KoalaJsonEatery) {
+		k.LocationURL = url
+	}
+}
+
+// fetch gets the url with our client and decodes the json body into v
+// Any non 2xx response is treated as an error, the body is always closed
+// Errors have a koala error kind so the server can tell a slow upstream from a broken one
+func (k *KoalaJsonEatery) fetch(ctx context.Context, url string, v interface{}) error {
+	resp, err := k.client.Get(ctx, url)
+	if err != nil {
+		return http.UpstreamError(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return http.StatusError(resp.StatusCode, url)
+	}
+
+	// the body is read as it is decoded so a dropped connection or deadline shows up here too
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		if ctx.Err() != nil {
+			return http.UpstreamError(err)
+		}
+		return koala.NewError("JsonDecodeErr", koala.ErrDecode, fmt.Errorf("Could not decode %s %w", url, err))
+	}
+
+	return nil
+}
+
 // This Stucture we used to parse the nested location info
 type koalaJsonEateryLocationParser struct {
 	Locations []struct {
@@ -56,13 +104,14 @@ type koalaJsonEateryLocationParser struct {
 			Country string `json:"country"`
 			State   string `json:"administrative_district_level_1"`
 		} `json:"address"`
+		Timezone       string   `json:"timezone"`
 		PaymentMethods []string `json:"capabilities"`
 		Telephone      string   `json:"phone_number"`
 		StoreHours     struct {
 			Periods []struct {
-				DayOfWeek string `json:"day_of_week"`
-				Opens     string `json:"start_local_time"`
-				Closes    string `json:"end_local_time"`
+				DayOfWeek koala.Weekday   `json:"day_of_week"`
+				Opens     koala.TimeOfDay `json:"start_local_time"`
+				Closes    koala.TimeOfDay `json:"end_local_time"`
 			} `json:"periods"`
 		} `json:"business_hours"`
 		Coordinates struct {
@@ -74,36 +123,62 @@ type koalaJsonEateryLocationParser struct {
 
 // Hits our location endpoint create the necessary config with a helper function
 // and return the provider info
-func (k *KoalaJsonEatery) GetProviderInfo() (*koala.ProviderInfo, error) {
-	// resp, err := k.client.Get(locationUrl)
-	f, err := os.Open(locationUrl)
+func (k *KoalaJsonEatery) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	p := &koalaJsonEateryLocationParser{}
 
-	if err != nil {
-		return nil, fmt.Errorf("LocationFetchErr: Could not get location %+v", err)
+	if err := k.fetch(ctx, k.LocationURL, p); err != nil {
+		return nil, fmt.Errorf("LocationFetchErr: Could not get location %w", err)
+	}
+
+	provider := createProviderInfo(p, k.LocationID)
+	if provider == nil {
+		return nil, k.notFound()
 	}
 
-	defer f.Close()
+	return provider, nil
+}
+
+// notFound is the error for a location ID that isn't in the eaterys location list
+func (k *KoalaJsonEatery) notFound() error {
+	return koala.NewError("LocationNotFoundErr", koala.ErrNotFound, fmt.Errorf("location %s is not in %s", k.LocationID, k.LocationURL))
+}
 
+// Locations lists the ID of every location the eatery sends in its location list
+func (k *KoalaJsonEatery) Locations(ctx context.Context) ([]string, error) {
 	p := &koalaJsonEateryLocationParser{}
+	if err := k.fetch(ctx, k.LocationURL, p); err != nil {
+		return nil, fmt.Errorf("LocationFetchErr: Could not get locations %w", err)
+	}
 
-	if err := json.NewDecoder(f).Decode(&p); err != nil {
-		return nil, fmt.Errorf("JsonDecodeErr: Could not decode menu %+v", err)
+	IDs := make([]string, 0, len(p.Locations))
+	for _, l := range p.Locations {
+		IDs = append(IDs, l.ID)
 	}
 
-	provider := createProviderInfo(p, k.LocationID)
+	return IDs, nil
+}
 
-	return provider, nil
+// ForLocation returns a copy of the eatery for another of its locations, the menu and client are shared
+// The eatery upstream has a single catalog for the whole business, it isn't scoped by location
+// so every location gets the same menu and only the provider info differs
+func (k *KoalaJsonEatery) ForLocation(ID string) http.AsyncProvider {
+	c := *k
+	c.LocationID = ID
+
+	return &c
 }
 
 // This function is unexported, we can use this function to test that our data is being transformed
 // correctly without having to necessarily worry about the implementation of our GetProviderInfo function
 // Given more time I would probalby add more abstraction, perhaps adding the ability for each provider to parse its own
 // data and then return an interface to make this even easier to work with
+// It returns nil when the location isn't in the list so we never serve an empty location
 func createProviderInfo(p *koalaJsonEateryLocationParser, ID string) *koala.ProviderInfo {
-	info := &koala.ProviderInfo{}
+	var info *koala.ProviderInfo
 loop:
 	for _, p := range p.Locations {
 		if p.ID == ID {
+			info = &koala.ProviderInfo{}
 			info.ID = p.ID
 			info.Name = p.Name
 			info.City = p.Address.City
@@ -114,6 +189,8 @@ loop:
 			info.Name = p.Name
 			info.Longitude = p.Coordinates.Longitude
 			info.Latitude = p.Coordinates.Latitude
+			info.Timezone = p.Timezone
+			// the eatery only sends weekly hours, it has nothing like the grills date overrides
 			for _, hrs := range p.StoreHours.Periods {
 				hour := &koala.ProviderHour{}
 				hour.DayOfWeek = hrs.DayOfWeek
@@ -142,15 +219,14 @@ type koalaJsonEateryMenuParser struct {
 			Name string `json:"name"`
 		} `json:"category_data"`
 		ModifierListData struct {
-			Name      string `json:"name"`
-			Modifiers []struct {
+			Name          string `json:"name"`
+			SelectionType string `json:"selection_type"`
+			Modifiers     []struct {
 				ID           string `json:"id"`
 				ModifierData struct {
-					Name      string `json:"name"`
-					PriceData struct {
-						Cost     float64 `json:"amount"`
-						Currency string  `json:"currency"`
-					} `json:"price_money"`
+					Name        string     `json:"name"`
+					OnByDefault bool       `json:"on_by_default"`
+					PriceData   priceMoney `json:"price_money"`
 				} `json:"modifier_data"`
 			} `json:"modifiers"`
 		} `json:"modifier_list_data"`
@@ -160,69 +236,106 @@ type koalaJsonEateryMenuParser struct {
 			Visibility       string `json:"visibility"`
 			CategoryID       string `json:"category_id"`
 			ModifierListInfo []struct {
-				ModifierListID string `json:"modifier_list_id"`
+				ModifierListID    string `json:"modifier_list_id"`
+				MinSelected       int    `json:"min_selected_modifiers"`
+				MaxSelected       int    `json:"max_selected_modifiers"`
+				ModifierOverrides []struct {
+					ModifierID  string `json:"modifier_id"`
+					OnByDefault bool   `json:"on_by_default"`
+				} `json:"modifier_overrides"`
 			} `json:"modifier_list_info"`
 			Variations []struct {
-				Type      string `json:"type"`
-				SKU       string `json:"sku"`
-				PriceData struct {
-					Cost     float64 `json:"amount"`
-					Currency string  `json:"currency"`
-				} `json:"price_money"`
-			}
+				Type          string `json:"type"`
+				ID            string `json:"id"`
+				Deleted       bool   `json:"is_deleted"`
+				VariationData struct {
+					Name      string     `json:"name"`
+					SKU       string     `json:"sku"`
+					PriceData priceMoney `json:"price_money"`
+				} `json:"item_variation_data"`
+			} `json:"variations"`
+			TimeRanges []struct {
+				From weekTime `json:"from"`
+				To   weekTime `json:"to"`
+			} `json:"time_ranges"`
 		} `json:"item_data"`
 	}
 }
 
-// TODO: Move these goroutines up to the handler so that we remove any of the magic
-// thats going on behind the seens, these functions are async and could block for the duration of the transport timeout
-// so putting the async calls at a higher level might be benficial for future developers
-func (k *KoalaJsonEatery) GetFullMenu() (*koala.Menu, error) {
-	menuChan := make(chan *koala.Menu, 1)
-	providerChan := make(chan *koala.ProviderInfo, 1)
+// The eatery sends every price as an integer amount of minor units with a currency code
+// EX: {"amount": 499, "currency": "USD"} is $4.99
+type priceMoney struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
 
-	f, err := os.Open(menuUrl)
+// weekTime is one end of an items time range EX: {"day": "Sunday", "time": "00:00"}
+type weekTime struct {
+	Day  koala.Weekday   `json:"day"`
+	Time koala.TimeOfDay `json:"time"`
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("MenuFetchErr: Could not fetch menu: %+v", err)
-	}
+// Free modifiers leave out price_money entirely so we fall back to the eaterys currency
+const defaultCurrency = "USD"
 
-	p := &koalaJsonEateryMenuParser{}
-	if err := json.NewDecoder(f).Decode(&p); err != nil {
-		return nil, fmt.Errorf("JsonDecodeErr: Could not decode menu %+v", err)
+// toMoney converts the upstream price into our common money type
+func (p priceMoney) toMoney() koala.Money {
+	if p.Currency == "" {
+		p.Currency = defaultCurrency
 	}
-	// run a go routine to parse the menu
-	// close the channel when done
-	go func(p *koalaJsonEateryMenuParser) {
-		menuChan <- createMenu(p)
-		close(menuChan)
-	}(p)
 
-	// response 2
-	f, err = os.Open(locationUrl)
-	if err != nil {
-		return nil, fmt.Errorf("LocationFetchErr: Could not fetch location: %+v", err)
-	}
+	return koala.NewMoney(p.Amount, p.Currency)
+}
 
-	// Parse
-	p2 := &koalaJsonEateryLocationParser{}
-	if err := json.NewDecoder(f).Decode(&p2); err != nil {
-		return nil, fmt.Errorf("JsonDecodeErr: Could not decode menu %+v", err)
+// TODO: Move these goroutines up to the handler so that we remove any of the magic
+// thats going on behind the seens, these functions are async and could block until ctx is done
+// so putting the async calls at a higher level might be benficial for future developers
+func (k *KoalaJsonEatery) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	// buffered so our goroutines never block even if we return early
+	menuChan := make(chan *koala.Menu, 1)
+	providerChan := make(chan *koala.ProviderInfo, 1)
+	errChan := make(chan error, 2)
+
+	// fetch and parse the menu
+	go func() {
+		p := &koalaJsonEateryMenuParser{}
+		if err := k.fetch(ctx, k.MenuURL, p); err != nil {
+			errChan <- fmt.Errorf("MenuFetchErr: Could not fetch menu: %w", err)
+			return
+		}
+		menuChan <- createMenu(p)
+	}()
+
+	// response 2, fetch the location at the same time
+	go func() {
+		p2 := &koalaJsonEateryLocationParser{}
+		if err := k.fetch(ctx, k.LocationURL, p2); err != nil {
+			errChan <- fmt.Errorf("LocationFetchErr: Could not fetch location: %w", err)
+			return
+		}
+		info := createProviderInfo(p2, k.LocationID)
+		if info == nil {
+			errChan <- k.notFound()
+			return
+		}
+		providerChan <- info
+	}()
+
+	// read from our channels until we have both halves,
+	// the first error or a cancelled context stops us waiting
+	var menu *koala.Menu
+	var providerInfo *koala.ProviderInfo
+	for menu == nil || providerInfo == nil {
+		select {
+		case menu = <-menuChan:
+		case providerInfo = <-providerChan:
+		case err := <-errChan:
+			return nil, err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("MenuFetchErr: %w", http.UpstreamError(ctx.Err()))
+		}
 	}
 
-	// Run this in another goroutine and then aggregate the data below
-	go func(p2 *koalaJsonEateryLocationParser) {
-		providerChan <- createProviderInfo(p2, k.LocationID)
-		close(providerChan)
-	}(p2)
-
-	f.Close()
-
-	// read from our channels getting the returned data
-	menu := <-menuChan
-
-	providerInfo := <-providerChan
-
 	menu.ProviderInfo = providerInfo
 
 	// return combined full menu with the provider info
@@ -230,72 +343,147 @@ func (k *KoalaJsonEatery) GetFullMenu() (*koala.Menu, error) {
 }
 
 // Get the data in a common format that we can share between our implementations
+// The catalog is a flat list where an item can come before the category or modifier list it points at,
+// so we make one pass to collect categories and modifier lists and a second pass to build the items
 func createMenu(p *koalaJsonEateryMenuParser) *koala.Menu {
-	cats := make(map[string]koala.Category)
-	items := []*koala.MenuItem{}
-	modifiers := make(map[string]koala.Modifier)
+	cats := make(map[string]*koala.Category)
+	modifiers := make(map[string]*koala.Modifier)
 	for _, value := range p.Objects {
-		mi := &koala.MenuItem{}
-		mod := &koala.Modifier{}
-		cat := &koala.Category{}
-		// switch on our top level items
-		// asinging the values we want to our transformed menu item
 		switch value.Type {
-		case "ITEM":
-			mi.ID = value.ID
-			mi.Name = value.ItemData.Name
-			mi.Description = value.ItemData.Description
-			mi.CategoryID = value.ItemData.CategoryID
-			mi.Disabled = value.Deleted
-			// if we have a modifier list attached to this
-			// the we need to set its list id so we can map it later
-			for _, v := range value.ItemData.ModifierListInfo {
-				mi.ModifierListID = v.ModifierListID
-			}
 		case "CATEGORY":
-			cat.ID = value.ID
-			cat.Name = value.CategoryData.Name
-			cats[value.ID] = *cat
+			cats[value.ID] = &koala.Category{
+				ID:       value.ID,
+				Name:     value.CategoryData.Name,
+				Disabled: value.Deleted,
+			}
 		case "MODIFIER_LIST":
-			mod.ID = value.ID
-			mod.Disabled = value.Deleted
-			mod.Name = value.ModifierListData.Name
+			mod := &koala.Modifier{
+				ID:       value.ID,
+				Name:     value.ModifierListData.Name,
+				Disabled: value.Deleted,
+			}
+			mod.Rules.SelectionType = koala.SelectionMultiple
+			if value.ModifierListData.SelectionType == koala.SelectionSingle {
+				mod.Rules.SelectionType = koala.SelectionSingle
+			}
+			// the eatery's modifiers are the options of our group,
+			// they never nest so each option has no groups of its own
 			for _, v := range value.ModifierListData.Modifiers {
-				mod.Options = append(
-					mod.Options,
-					struct {
-						Name string  `xml:"name,attr" json:"name"`
-						Cost float64 `xml:"cost,attr" json:"cost"`
-					}{
-						Name: v.ModifierData.Name,
-						Cost: v.ModifierData.PriceData.Cost,
-					},
-				)
+				mod.Options = append(mod.Options, &koala.ModifierOption{
+					ID:      v.ID,
+					Name:    v.ModifierData.Name,
+					Default: v.ModifierData.OnByDefault,
+					Cost:    v.ModifierData.PriceData.toMoney(),
+				})
 			}
-			// we want to map our modifier list id
-			// to our modifier and options
-			// then we can associate it
-			// with menu item below
-			modifiers[value.ID] = *mod
+			modifiers[value.ID] = mod
+		}
+	}
+
+	items := []*koala.MenuItem{}
+	for _, value := range p.Objects {
+		if value.Type != "ITEM" {
+			continue
 		}
 
+		mi := &koala.MenuItem{}
+		mi.ID = value.ID
+		mi.Name = value.ItemData.Name
+		mi.Description = value.ItemData.Description
+		mi.CategoryID = value.ItemData.CategoryID
+		mi.Disabled = value.Deleted
 		// o(1) lookup for our categories
-		// lets map them to our menuItem
+		// lets map them to our menuItem, we copy so items don't share a pointer
 		if c, hit := cats[mi.CategoryID]; hit {
-			mi.Category = &c
+			cat := *c
+			mi.Category = &cat
 		}
-		// same as above if we have a matching modifier
+		// same as above if we have a matching modifier list
 		// then we want to attach it to our menu item.
-		// ignoring varations for sake of brevity
-		if mod, hit := modifiers[mi.ModifierListID]; hit {
-			mi.Modifiers = append(mi.Modifiers, &mod)
+		// The item can override the lists limits and defaults so every item gets its own copy
+		for _, v := range value.ItemData.ModifierListInfo {
+			mi.ModifierListID = v.ModifierListID
+			list, hit := modifiers[v.ModifierListID]
+			if !hit {
+				continue
+			}
+
+			mod := cloneModifier(list)
+
+			defaults := make(map[string]bool)
+			for _, o := range v.ModifierOverrides {
+				defaults[o.ModifierID] = o.OnByDefault
+			}
+			for _, o := range mod.Options {
+				if d, hit := defaults[o.ID]; hit {
+					o.Default = d
+				}
+			}
+
+			mod.Rules = createRules(mod.Rules.SelectionType, v.MinSelected, v.MaxSelected)
+			mi.Modifiers = append(mi.Modifiers, mod)
 		}
-		// if our menu item has a zero value
-		// for its id we don't store it (we probably hit another case and menu item is a 0 value struct)
-		if mi.ID != "" {
-			items = append(items, mi)
+		for _, v := range value.ItemData.Variations {
+			if v.Deleted {
+				continue
+			}
+			mi.Variations = append(mi.Variations, &koala.Variation{
+				ID:    v.ID,
+				Name:  v.VariationData.Name,
+				SKU:   v.VariationData.SKU,
+				Price: v.VariationData.PriceData.toMoney(),
+			})
+		}
+		// items without time ranges can be ordered any time so we leave availability nil
+		if len(value.ItemData.TimeRanges) > 0 {
+			mi.Availability = &koala.Availability{}
+			for _, v := range value.ItemData.TimeRanges {
+				mi.Availability.Times = append(mi.Availability.Times, &koala.TimeRange{
+					From: koala.WeekTime{Day: v.From.Day, Time: v.From.Time},
+					To:   koala.WeekTime{Day: v.To.Day, Time: v.To.Time},
+				})
+			}
 		}
+
+		items = append(items, mi)
 	}
 	// return our menu
 	return &koala.Menu{MenuItems: items}
 }
+
+// cloneModifier copies a modifier list and its options so per item overrides don't leak to other items
+func cloneModifier(m *koala.Modifier) *koala.Modifier {
+	c := *m
+	c.Options = make([]*koala.ModifierOption, 0, len(m.Options))
+	for _, o := range m.Options {
+		opt := *o
+		c.Options = append(c.Options, &opt)
+	}
+
+	return &c
+}
+
+// createRules normalizes the eaterys selection settings
+// The item sends -1 for a limit it doesn't set, a SINGLE list without limits is a pick at most one
+func createRules(selectionType string, min, max int) koala.SelectionRules {
+	r := koala.SelectionRules{SelectionType: selectionType}
+
+	if min > 0 {
+		r.MinSelects = min
+	}
+
+	switch {
+	case max > 0:
+		r.MaxSelects = max
+	case selectionType == koala.SelectionSingle:
+		r.MaxSelects = 1
+	}
+
+	if r.MaxSelects == 1 {
+		r.SelectionType = koala.SelectionSingle
+	}
+
+	r.Mandatory = r.MinSelects > 0
+
+	return r
+}
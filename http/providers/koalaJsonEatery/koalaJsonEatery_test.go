@@ -0,0 +1,69 @@
+package koalaJsonEatery_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	koala "github.com/ko1eda/apiaggregator"
+	"github.com/ko1eda/apiaggregator/http"
+	"github.com/ko1eda/apiaggregator/http/providers/koalaJsonEatery"
+	"github.com/ko1eda/apiaggregator/http/providertest"
+)
+
+func TestProvider(t *testing.T) {
+	c := providertest.Case{
+		Name:     "koala-json-eatery",
+		Fixtures: "../../../goldenfiles",
+		Expected: "../../../goldenfiles/expected",
+		New: func(c http.HttpGetter) http.AsyncProvider {
+			return koalaJsonEatery.NewProvider(c)
+		},
+	}
+
+	providertest.Run(t, c)
+	providertest.RunFaults(t, c)
+}
+
+// one eatery serves every location in its list, the upstream has one menu for all of them
+// so the second location has its own info and the same menu
+func TestForLocation(t *testing.T) {
+	c := providertest.Case{
+		Name:     "koala-json-eatery-3",
+		Fixtures: "../../../goldenfiles",
+		Expected: "../../../goldenfiles/expected",
+		New: func(c http.HttpGetter) http.AsyncProvider {
+			p := koalaJsonEatery.NewProvider(c, koalaJsonEatery.WithLocationURL("file:///json-eatery-locations.json"))
+			return p.ForLocation("3")
+		},
+	}
+
+	providertest.Run(t, c)
+}
+
+func TestLocations(t *testing.T) {
+	p := koalaJsonEatery.NewProvider(
+		http.NewClient(http.WithFileRoot("../../../goldenfiles")),
+		koalaJsonEatery.WithLocationURL("file:///json-eatery-locations.json"),
+	)
+
+	IDs, err := p.Locations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(IDs, want) {
+		t.Errorf("got %v, want %v", IDs, want)
+	}
+}
+
+func TestLocationNotFound(t *testing.T) {
+	p := koalaJsonEatery.NewProvider(http.NewClient(http.WithFileRoot("../../../goldenfiles")), koalaJsonEatery.WithLocationID("99"))
+
+	if info, err := p.GetProviderInfo(context.Background()); !errors.Is(err, koala.ErrNotFound) {
+		t.Errorf("GetProviderInfo got %+v and %v, want koala.ErrNotFound", info, err)
+	}
+	if _, err := p.GetFullMenu(context.Background()); !errors.Is(err, koala.ErrNotFound) {
+		t.Errorf("GetFullMenu got %v, want koala.ErrNotFound", err)
+	}
+}
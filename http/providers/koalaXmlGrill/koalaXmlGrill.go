@@ -1,10 +1,12 @@
 package koalaXmlGrill
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"strings"
+	"time"
 
 	koala "github.com/ko1eda/apiaggregator"
 	"github.com/ko1eda/apiaggregator/http"
@@ -13,20 +15,29 @@ import (
 // we do a runtime check to ensure our item implenets our AsyncProviderService
 var _ http.AsyncProvider = &KoalaXmlGrill{}
 
-// Relative to root of project
+// The grill doesn't send a currency with its prices, all of its locations are in the US
+const currency = "USD"
+
+// The grill doesn't send a timezone either, all of its locations are currently in New York
+const defaultTimezone = "America/New_York"
+
+// Default upstream endpoint, this points at our golden file
+// so it needs a client that can serve file:// urls (see http.WithFileRoot)
 var (
-	menuUrl = "./goldenfiles/xml-grill-data.xml"
+	menuUrl = "file:///xml-grill-data.xml"
 )
 
-// Represents our JsonEatery data provider
+// Represents our XmlGrill data provider
 type KoalaXmlGrill struct {
 	LocationID string
+	MenuURL    string
+	Timezone   string
 	client     http.HttpGetter
 }
 
-// Return a new jsonEatery with sensible defaults and variadic modifier params
+// Return a new xmlGrill with sensible defaults and variadic modifier params
 func NewProvider(c http.HttpGetter, opts ...func(*KoalaXmlGrill)) *KoalaXmlGrill {
-	k := &KoalaXmlGrill{client: c, LocationID: "1"}
+	k := &KoalaXmlGrill{client: c, LocationID: "1", MenuURL: menuUrl, Timezone: defaultTimezone}
 	for _, opt := range opts {
 		opt(k)
 	}
@@ -43,10 +54,68 @@ func WithLocationID(ID string) func(*KoalaXmlGrill) {
 	}
 }
 
+// WithMenuURL sets the upstream endpoint, the grill serves its location and menu from the same document
+func WithMenuURL(url string) func(*KoalaXmlGrill) {
+	return func(k *KoalaXmlGrill) {
+		k.MenuURL = url
+	}
+}
+
+// WithTimezone sets the IANA timezone the locations hours are in EX: America/Chicago
+func WithTimezone(tz string) func(*KoalaXmlGrill) {
+	return func(k *KoalaXmlGrill) {
+		k.Timezone = tz
+	}
+}
+
+// fetch gets the url with our client and reads the whole xml body,
+// the location and the menu live in the same document so one read can be decoded twice
+// Any non 2xx response is treated as an error, the body is always closed
+// Errors have a koala error kind so the server can tell a slow upstream from a broken one
+func (k *KoalaXmlGrill) fetch(ctx context.Context, url string) ([]byte, error) {
+	resp, err := k.client.Get(ctx, url)
+	if err != nil {
+		return nil, http.UpstreamError(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, http.StatusError(resp.StatusCode, url)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, http.UpstreamError(fmt.Errorf("XmlReadErr: Could not read %s %w", url, err))
+	}
+
+	return b, nil
+}
+
+// decode unmarshals the document fetched from url into v
+func decode(b []byte, url string, v interface{}) error {
+	if err := xml.Unmarshal(b, v); err != nil {
+		return koala.NewError("XmlDecodeErr", koala.ErrDecode, fmt.Errorf("Could not decode %s %w", url, err))
+	}
+
+	return nil
+}
+
+// checkLocation makes sure the document is for the location we were set up with,
+// the grill serves one location per url so a wrong url would otherwise serve another stores menu
+func (k *KoalaXmlGrill) checkLocation(ID string) error {
+	if ID == k.LocationID {
+		return nil
+	}
+
+	return koala.NewError("LocationMismatchErr", koala.ErrLocationMismatch, fmt.Errorf("asked for location %s but %s is for location %s", k.LocationID, k.MenuURL, ID))
+}
+
 // Parse our xml attributes
 // Unlike json we can parse nested elements with > tag modifier
 // We don't habve to make this struct as deeply nested to pull the data we want
 type xmlLocationParser struct {
+	xmlHandoffSupport
 	ID             string   `xml:"id,attr"`
 	Name           string   `xml:"name,attr"`
 	StreetAddress  string   `xml:"streetaddress,attr"`
@@ -59,53 +128,68 @@ type xmlLocationParser struct {
 	Latitude       float64  `xml:"latitude,attr"`
 	PaymentMethods []string `xml:"billingdetails>billingmethods>billingmethod"`
 	StoreHours     []struct {
-		DayOfWeek string `xml:"day,attr"`
-		Opens     string `xml:"from,attr"`
-		Closes    string `xml:"to,attr"`
-		Type      string `xml:"type,attr"`
+		DayOfWeek koala.Weekday   `xml:"day,attr"`
+		Opens     koala.TimeOfDay `xml:"from,attr"`
+		Closes    koala.TimeOfDay `xml:"to,attr"`
+		Type      string          `xml:"type,attr"`
 	} `xml:"hours>period"`
+	OverrideHours []struct {
+		Date   date            `xml:"date,attr"`
+		Opens  koala.TimeOfDay `xml:"from,attr"`
+		Closes koala.TimeOfDay `xml:"to,attr"`
+		Type   string          `xml:"type,attr"`
+	} `xml:"allhours>overridehours>dateperiod"`
 }
 
 // Get the provider info
-func (k *KoalaXmlGrill) GetProviderInfo() (*koala.ProviderInfo, error) {
-	f, err := os.Open(menuUrl)
-
+func (k *KoalaXmlGrill) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	b, err := k.fetch(ctx, k.MenuURL)
 	if err != nil {
-		return nil, fmt.Errorf("LocationFetchErr: Could not get location %+v", err)
+		return nil, fmt.Errorf("LocationFetchErr: Could not get location %w", err)
 	}
 
-	defer f.Close()
-
 	p := &xmlLocationParser{}
-	if err := xml.NewDecoder(f).Decode(&p); err != nil {
-		return nil, fmt.Errorf("XmlDecodeErr: Could not decode menu %+v", err)
+	if err := decode(b, k.MenuURL, p); err != nil {
+		return nil, fmt.Errorf("LocationFetchErr: Could not get location %w", err)
+	}
+	if err := k.checkLocation(p.ID); err != nil {
+		return nil, err
 	}
 
-	provider := createProviderInfo(p)
+	provider := createProviderInfo(p, k.Timezone)
 
 	return provider, nil
 }
 
-func createProviderInfo(p *xmlLocationParser) *koala.ProviderInfo {
-	// we normalize the data here
+func createProviderInfo(p *xmlLocationParser, timezone string) *koala.ProviderInfo {
+	// days and times are normalized as they are decoded
 	hrs := []*koala.ProviderHour{}
 	for _, hr := range p.StoreHours {
-		// make this look more like our json grill
-		hr.Opens += ":00"
-		hr.Closes += ":00"
-		hr.DayOfWeek = strings.ToUpper(hr.DayOfWeek)[:3]
-
 		// convert our hours struct into the proper format
 		v := &koala.ProviderHour{
 			DayOfWeek: hr.DayOfWeek,
 			Closes:    hr.Closes,
 			Opens:     hr.Opens,
-			Type:      hr.Type,
+			Type:      handoffMode(hr.Type),
 		}
 
 		hrs = append(hrs, v)
 	}
 
+	// closures and special hours for a single date
+	var overrides []*koala.OverrideHour
+	for _, o := range p.OverrideHours {
+		if !o.Date.Valid {
+			continue
+		}
+		overrides = append(overrides, &koala.OverrideHour{
+			Type:   handoffMode(o.Type),
+			Date:   o.Date.Format(koala.DateLayout),
+			Opens:  o.Opens,
+			Closes: o.Closes,
+		})
+	}
+
 	return &koala.ProviderInfo{
 		ID:             p.ID,
 		Name:           p.Name,
@@ -117,129 +201,335 @@ func createProviderInfo(p *xmlLocationParser) *koala.ProviderInfo {
 		Telephone:      p.Telephone,
 		Longitude:      p.Longitude,
 		Latitude:       p.Latitude,
+		Timezone:       timezone,
 		StoreHours:     hrs,
+		OverrideHours:  overrides,
+		HandoffModes:   p.modes(),
 		PaymentMethods: p.PaymentMethods,
 	}
 }
 
-// Im certain I could find a more efficient way to parse this
-// But for times sake this will have to do.
-type xmlMenuParser struct {
-	Menu []struct {
-		Category []struct {
-			Name     string `xml:"name,attr"`
-			ID       string `xml:"id,attr"`
-			ItemData struct {
-				Name             string `xml:"name,attr"`
-				Description      string `xml:"description,attr"`
-				ModifierListData []struct {
-					Description string `xml:"description,attr"`
-					Modifiers   []struct {
-						ID           string  `xml:"id,attr"`
-						Name         string  `xml:"name,attr"`
-						Cost         float64 `xml:"cost,attr"`
-						ModifierData []struct {
-							ID              string `xml:"id,attr"`
-							ModifierOptions []struct {
-								Name string  `xml:"name,attr" json:"name"`
-								Cost float64 `xml:"cost,attr" json:"cost"`
-							} `xml:"options>option"`
-						} `xml:"modifiers>optiongroup"`
-					} `xml:"options>option"`
-				} `xml:"modifiers>optiongroup"`
-			} `xml:"products>product"`
-		} `xml:"categories>category"`
-	} `xml:"menu"`
-}
-
-// Get full menu, this runs some go routines, and uses buffered channel of 1 to return the data from the endpoints
-// ayncronously,
-func (k *KoalaXmlGrill) GetFullMenu() (*koala.Menu, error) {
-	// resp, err := k.client.Get(menuUrl)
-	menuChan := make(chan *koala.Menu, 1)
-	providerChan := make(chan *koala.ProviderInfo, 1)
-
-	f, err := os.Open(menuUrl)
+// cost is the grills price attribute in decimal dollars EX: cost="6.0000"
+// It decodes straight into our money type so the price is never held as a float
+type cost koala.Money
 
+func (c *cost) UnmarshalXMLAttr(attr xml.Attr) error {
+	m, err := koala.ParseMoney(attr.Value, currency)
 	if err != nil {
-		return nil, fmt.Errorf("MenuFetchErr: Could not fetch menu: %+v", err)
+		return err
 	}
 
-	p2 := &xmlMenuParser{}
-	if err := xml.NewDecoder(f).Decode(&p2); err != nil {
-		return nil, fmt.Errorf("XmlDecodeErr: Could not decode menu %+v", err)
+	*c = cost(m)
+
+	return nil
+}
+
+// toMoney returns the cost as money, a missing cost attribute is free
+func (c cost) toMoney() koala.Money {
+	if c.Currency == "" {
+		return koala.NewMoney(c.Amount, currency)
+	}
+
+	return koala.Money(c)
+}
+
+// xmlMenuParser reads the menu out of the same restaurant document as the location,
+// it embeds the location parser so the full menu decodes the document once for both
+// Products and their option groups are parsed with the recursive types below
+// so an option can open groups of its own to any depth
+type xmlMenuParser struct {
+	xmlLocationParser
+	Categories []struct {
+		ID       string        `xml:"id,attr"`
+		Name     string        `xml:"name,attr"`
+		Products []*xmlProduct `xml:"products>product"`
+	} `xml:"menu>categories>category"`
+}
+
+// The handoff modes the restaurant supports, the menu needs these as well as the location
+// because products only list the modes they are unavailable for
+type xmlHandoffSupport struct {
+	Curbside  bool `xml:"supportscurbside,attr"`
+	Dispatch  bool `xml:"supportsdispatch,attr"`
+	DriveThru bool `xml:"supportsdrivethru,attr"`
+	DineIn    bool `xml:"supportsdinein,attr"`
+}
+
+// modes converts the flags into our handoff modes, every grill location supports pickup
+func (h xmlHandoffSupport) modes() koala.HandoffModes {
+	modes := koala.HandoffModes{koala.HandoffPickup}
+	if h.Curbside {
+		modes = append(modes, koala.HandoffCurbside)
+	}
+	if h.Dispatch {
+		modes = append(modes, koala.HandoffDelivery)
+	}
+	if h.DriveThru {
+		modes = append(modes, koala.HandoffDriveThru)
+	}
+	if h.DineIn {
+		modes = append(modes, koala.HandoffDineIn)
+	}
+
+	return modes
+}
+
+// handoffMode normalizes a grill mode name EX: dispatch is our delivery
+// a name we don't know is kept as is so no hours are silently dropped
+func handoffMode(s string) koala.HandoffMode {
+	if m, err := koala.ParseHandoffMode(s); err == nil {
+		return m
+	}
+
+	return koala.HandoffMode(strings.ToLower(s))
+}
+
+// A single product in a category
+type xmlProduct struct {
+	ID          string            `xml:"id,attr"`
+	Name        string            `xml:"name,attr"`
+	Description string            `xml:"description,attr"`
+	Cost        cost              `xml:"cost,attr"`
+	Disabled    bool              `xml:"isdisabled,attr"`
+	Groups      []*xmlOptionGroup `xml:"modifiers>optiongroup"`
+	Available   *xmlAvailability  `xml:"availability"`
+	Unavailable []string          `xml:"unavailablehandoffmodes>handoffmode"`
+}
+
+// When a product can be ordered, nil dates are sent as empty elements with xsi:nil
+type xmlAvailability struct {
+	StartDate date `xml:"startDate"`
+	EndDate   date `xml:"endDate"`
+	Times     []struct {
+		From xmlWeekTime `xml:"from"`
+		To   xmlWeekTime `xml:"to"`
+	} `xml:"times>timerange"`
+}
+
+// One end of a timerange EX: <from day="Monday" time="00:00" />
+type xmlWeekTime struct {
+	Day  koala.Weekday   `xml:"day,attr"`
+	Time koala.TimeOfDay `xml:"time,attr"`
+}
+
+// date is an optional grill date EX: 2020-11-26T00:00:00, an empty element is no date
+// The grill sends these as local dates without a timezone, they are parsed as a wall clock
+// and put in the locations timezone with in once we know it
+type date struct {
+	time.Time
+	Valid bool
+}
+
+func (d *date) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := dec.DecodeElement(&s, &start); err != nil {
+		return err
 	}
 
-	// run a go routine to parse the menu
-	// close the channel when done
-	go func(p2 *xmlMenuParser) {
-		menuChan <- createMenu(p2)
-		close(menuChan)
-	}(p2)
+	return d.parse(s)
+}
 
-	f.Close()
+func (d *date) parse(s string) error {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
 
-	f, err = os.Open(menuUrl)
-	// make another call though
-	// we can probably achieve this with a noop closer
-	// so we don't have to make two calls
-	// resp, err = k.client.Get(menuUrl)
+	t, err := time.Parse("2006-01-02T15:04:05", s)
 	if err != nil {
-		return nil, fmt.Errorf("LocationFetchErr: Could not fetch location: %+v", err)
+		return fmt.Errorf("DateParseErr: %q %w", s, err)
 	}
 
-	defer f.Close()
-	p := &xmlLocationParser{}
-	if err := xml.NewDecoder(f).Decode(&p); err != nil {
-		return nil, fmt.Errorf("XmlDecodeErr: Could not decode menu %+v", err)
+	d.Time, d.Valid = t, true
+
+	return nil
+}
+
+// The same date format is used in attributes EX: <dateperiod date="2020-11-26T00:00:00" />
+func (d *date) UnmarshalXMLAttr(attr xml.Attr) error {
+	return d.parse(attr.Value)
+}
+
+// in returns the date on the locations wall clock, nil for a missing date so it is left out of our json
+func (d date) in(loc *time.Location) *time.Time {
+	if !d.Valid {
+		return nil
 	}
 
-	go func(p *xmlLocationParser) {
-		providerChan <- createProviderInfo(p)
-		close(providerChan)
-	}(p)
+	t := time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), loc)
+
+	return &t
+}
+
+// An optiongroup is a choice the customer makes about a product or about another option
+type xmlOptionGroup struct {
+	ID             string       `xml:"id,attr"`
+	Description    string       `xml:"description,attr"`
+	Mandatory      bool         `xml:"mandatory,attr"`
+	MinSelects     *int         `xml:"minselects,attr"`
+	MaxSelects     *int         `xml:"maxselects,attr"`
+	MinAggQuantity int          `xml:"minaggregatequantity,attr"`
+	MaxAggQuantity int          `xml:"maxaggregatequantity,attr"`
+	Options        []*xmlOption `xml:"options>option"`
+}
 
-	menu := <-menuChan
+// An option inside a group, its own modifiers element holds any nested groups
+type xmlOption struct {
+	ID        string            `xml:"id,attr"`
+	Name      string            `xml:"name,attr"`
+	Cost      cost              `xml:"cost,attr"`
+	IsDefault bool              `xml:"isdefault,attr"`
+	Groups    []*xmlOptionGroup `xml:"modifiers>optiongroup"`
+}
 
-	providerInfo := <-providerChan
+// Get full menu, the grill sends its location and menu in one document
+// so we fetch and decode it once for both
+func (k *KoalaXmlGrill) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	b, err := k.fetch(ctx, k.MenuURL)
+	if err != nil {
+		return nil, fmt.Errorf("MenuFetchErr: Could not fetch menu: %w", err)
+	}
+
+	p := &xmlMenuParser{}
+	if err := decode(b, k.MenuURL, p); err != nil {
+		return nil, fmt.Errorf("MenuFetchErr: Could not fetch menu: %w", err)
+	}
+	if err := k.checkLocation(p.ID); err != nil {
+		return nil, err
+	}
+
+	info := createProviderInfo(&p.xmlLocationParser, k.Timezone)
+	loc, err := info.Location()
+	if err != nil {
+		return nil, err
+	}
 
-	menu.ProviderInfo = providerInfo
+	menu := createMenu(p, loc)
+	menu.ProviderInfo = info
 
 	return menu, nil
 }
 
-// Create a menu, Improvements would be parsing this without a nested loop
-func createMenu(p *xmlMenuParser) *koala.Menu {
+// Create a menu, every product in every category becomes one menu item
+// loc is the locations timezone the availability dates are read in
+func createMenu(p *xmlMenuParser, loc *time.Location) *koala.Menu {
+	modes := p.modes()
 	items := []*koala.MenuItem{}
-	mods := []*koala.Modifier{}
-	// deeply nested for loop
-	// refactor
-	for _, categories := range p.Menu {
-		for _, item := range categories.Category {
+	for _, category := range p.Categories {
+		for _, product := range category.Products {
 			mi := &koala.MenuItem{}
-			cat := &koala.Category{}
-			cat.Name = item.Name
-			cat.ID = item.ID
-			mi.Name = item.ItemData.Name
-			mi.Description = item.ItemData.Description
-			mi.Category = cat
-			for _, mod := range item.ItemData.ModifierListData {
-				for _, f := range mod.Modifiers {
-					m := &koala.Modifier{}
-					m.ID = f.ID
-					m.Cost = f.Cost
-					m.Name = f.Name
-					for _, omg := range f.ModifierData {
-						m.Options = omg.ModifierOptions
-						mods = append(mods, m)
-						mi.Modifiers = mods
-						break
-					}
-				}
+			mi.ID = product.ID
+			mi.Name = product.Name
+			mi.Description = product.Description
+			mi.Disabled = product.Disabled
+			mi.CategoryID = category.ID
+			mi.Category = &koala.Category{ID: category.ID, Name: category.Name}
+			// a grill product has a single price, so it maps to one variation
+			mi.Variations = []*koala.Variation{{
+				ID:    product.ID,
+				Name:  "Regular",
+				Price: product.Cost.toMoney(),
+			}}
+			mi.Modifiers = createModifierGroups(product.Groups)
+			mi.Availability = createAvailability(product.Available, loc)
+			// products only list what they can't do, so start from what the restaurant supports
+			unavailable := make([]koala.HandoffMode, 0, len(product.Unavailable))
+			for _, m := range product.Unavailable {
+				unavailable = append(unavailable, handoffMode(m))
 			}
+			mi.HandoffModes = modes.Without(unavailable...)
 			items = append(items, mi)
 		}
 	}
 
 	return &koala.Menu{MenuItems: items}
 }
+
+// createAvailability converts the products availability, a product without one is always available
+func createAvailability(a *xmlAvailability, loc *time.Location) *koala.Availability {
+	if a == nil {
+		return nil
+	}
+
+	av := &koala.Availability{
+		StartDate: a.StartDate.in(loc),
+		EndDate:   a.EndDate.in(loc),
+	}
+	for _, t := range a.Times {
+		av.Times = append(av.Times, &koala.TimeRange{
+			From: koala.WeekTime{Day: t.From.Day, Time: t.From.Time},
+			To:   koala.WeekTime{Day: t.To.Day, Time: t.To.Time},
+		})
+	}
+
+	return av
+}
+
+// createModifierGroups converts option groups and everything nested below them,
+// it calls itself for the groups under each option so no depth is lost
+func createModifierGroups(groups []*xmlOptionGroup) []*koala.ModifierGroup {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	mods := make([]*koala.ModifierGroup, 0, len(groups))
+	for _, g := range groups {
+		mod := &koala.ModifierGroup{
+			ID:      g.ID,
+			Name:    g.Description,
+			Rules:   createRules(g),
+			Options: make([]*koala.ModifierOption, 0, len(g.Options)),
+		}
+
+		for _, o := range g.Options {
+			mod.Options = append(mod.Options, &koala.ModifierOption{
+				ID:        o.ID,
+				Name:      o.Name,
+				Default:   o.IsDefault,
+				Cost:      o.Cost.toMoney(),
+				Modifiers: createModifierGroups(o.Groups),
+			})
+		}
+
+		mods = append(mods, mod)
+	}
+
+	return mods
+}
+
+// createRules normalizes the grills selection attributes
+// A mandatory group without explicit limits is a pick one, an optional group without them has no limit
+func createRules(g *xmlOptionGroup) koala.SelectionRules {
+	r := koala.SelectionRules{
+		Mandatory:   g.Mandatory,
+		MinQuantity: g.MinAggQuantity,
+		MaxQuantity: g.MaxAggQuantity,
+	}
+
+	switch {
+	case g.MinSelects != nil:
+		r.MinSelects = *g.MinSelects
+	case g.Mandatory:
+		r.MinSelects = 1
+	}
+
+	switch {
+	case g.MaxSelects != nil:
+		r.MaxSelects = *g.MaxSelects
+	case g.Mandatory:
+		r.MaxSelects = 1
+	}
+
+	// a group that needs a pick has to allow at least one,
+	// and a group with a minimum is mandatory however the grill flagged it
+	if r.Mandatory && r.MinSelects < 1 {
+		r.MinSelects = 1
+	}
+	r.Mandatory = r.MinSelects > 0
+
+	r.SelectionType = koala.SelectionMultiple
+	if r.MaxSelects == 1 {
+		r.SelectionType = koala.SelectionSingle
+	}
+
+	return r
+}
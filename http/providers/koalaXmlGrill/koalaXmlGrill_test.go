@@ -0,0 +1,76 @@
+package koalaXmlGrill_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+	"github.com/ko1eda/apiaggregator/http"
+	"github.com/ko1eda/apiaggregator/http/providers/koalaXmlGrill"
+	"github.com/ko1eda/apiaggregator/http/providertest"
+)
+
+func TestProvider(t *testing.T) {
+	c := providertest.Case{
+		Name:     "koala-xml-grill",
+		Fixtures: "../../../goldenfiles",
+		Expected: "../../../goldenfiles/expected",
+		New: func(c http.HttpGetter) http.AsyncProvider {
+			return koalaXmlGrill.NewProvider(c)
+		},
+	}
+
+	providertest.Run(t, c)
+	providertest.RunFaults(t, c)
+}
+
+// a url for another store has to be an error rather than that stores menu under our location
+func TestLocationMismatch(t *testing.T) {
+	p := koalaXmlGrill.NewProvider(http.NewClient(http.WithFileRoot("../../../goldenfiles")), koalaXmlGrill.WithLocationID("7"))
+
+	if _, err := p.GetProviderInfo(context.Background()); !errors.Is(err, koala.ErrLocationMismatch) {
+		t.Errorf("GetProviderInfo got %v, want koala.ErrLocationMismatch", err)
+	}
+	if _, err := p.GetFullMenu(context.Background()); !errors.Is(err, koala.ErrLocationMismatch) {
+		t.Errorf("GetFullMenu got %v, want koala.ErrLocationMismatch", err)
+	}
+}
+
+// the grill sends local dates, they have to come out as that wall clock in the locations timezone
+func TestAvailabilityDates(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	b, err := ioutil.ReadFile("../../../goldenfiles/xml-grill-data.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := strings.Replace(string(b), `<startDate xsi:nil="true" />`, `<startDate>2020-12-01T00:00:00</startDate>`, 1)
+	doc = strings.Replace(doc, `<endDate xsi:nil="true" />`, `<endDate>2020-12-31T23:59:59</endDate>`, 1)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "xml-grill-data.xml"), []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := koalaXmlGrill.NewProvider(http.NewClient(http.WithFileRoot(dir)), koalaXmlGrill.WithTimezone("America/Chicago"))
+	menu, err := p.GetFullMenu(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := menu.MenuItems[0].Availability
+	if want := time.Date(2020, 12, 1, 0, 0, 0, 0, chicago); a.StartDate == nil || !a.StartDate.Equal(want) {
+		t.Errorf("start date is %v, want %v", a.StartDate, want)
+	}
+	if want := time.Date(2020, 12, 31, 23, 59, 59, 0, chicago); a.EndDate == nil || !a.EndDate.Equal(want) {
+		t.Errorf("end date is %v, want %v", a.EndDate, want)
+	}
+}
@@ -0,0 +1,720 @@
+package mapping
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// record is one selected node and the fields the spec maps from it
+type record struct {
+	node   interface{}
+	fields map[string]*Field
+}
+
+// each returns a record for every node the collection selects from n, leaving out skipped nodes
+// A nil collection selects nothing
+func (c *Collection) each(n interface{}) []*record {
+	if c == nil {
+		return nil
+	}
+
+	var out []*record
+	for _, node := range c.selectPath.Eval(n) {
+		if c.Skip != "" {
+			if v, hit := c.skipPath.First(node); hit && isTrue(toString(v)) {
+				continue
+			}
+		}
+		out = append(out, &record{node: node, fields: c.Fields})
+	}
+
+	return out
+}
+
+// text returns the transformed text of a field and whether the upstream sent it,
+// a field that isn't in the spec or wasn't sent gives its default
+func (r *record) text(name string) (string, bool) {
+	f, hit := r.fields[name]
+	if !hit {
+		return "", false
+	}
+
+	var s string
+	if f.Path != "" {
+		v, _ := f.path.First(r.node)
+		s = toString(v)
+	}
+	if s == "" {
+		if f.Default == "" {
+			return "", false
+		}
+		s = f.Default
+	}
+
+	return f.transform(s), true
+}
+
+// texts returns the transformed text of every node the field selects EX: a list of payment methods
+func (r *record) texts(name string) []string {
+	f, hit := r.fields[name]
+	if !hit {
+		return nil
+	}
+
+	var out []string
+	for _, v := range f.path.Eval(r.node) {
+		out = append(out, f.transform(toString(v)))
+	}
+
+	return out
+}
+
+func (f *Field) transform(s string) string {
+	for _, t := range f.Transforms {
+		s = t.apply(s)
+	}
+
+	return s
+}
+
+// str is a text field that is empty when it wasn't sent
+func (r *record) str(name string) string {
+	s, _ := r.text(name)
+
+	return s
+}
+
+func (r *record) bool(name string) bool {
+	return isTrue(r.str(name))
+}
+
+func isTrue(s string) bool {
+	b, _ := strconv.ParseBool(strings.TrimSpace(s))
+
+	return b
+}
+
+// int reads a whole number field, set is false when it wasn't sent or is negative
+// since upstreams use -1 for a limit they don't set
+func (r *record) int(name string) (n int, set bool, err error) {
+	s, hit := r.text(name)
+	if !hit {
+		return 0, false, nil
+	}
+
+	n, err = strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, false, fmt.Errorf("%s %q is not a whole number", name, s)
+	}
+
+	return n, n >= 0, nil
+}
+
+func (r *record) float(name string) (float64, error) {
+	s, hit := r.text(name)
+	if !hit {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is not a number", name, s)
+	}
+
+	return f, nil
+}
+
+func (r *record) weekday(name string) (koala.Weekday, error) {
+	return koala.ParseWeekday(r.str(name))
+}
+
+func (r *record) timeOfDay(name string) (koala.TimeOfDay, error) {
+	return koala.ParseTimeOfDay(r.str(name))
+}
+
+// the date formats we try in order, upstreams usually send a local date without a timezone
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", koala.DateLayout}
+
+// date reads a date field in loc, it is nil when the upstream didn't send one
+// A date without an offset is on the locations wall clock so it is compared to the right instant
+func (r *record) date(name string, loc *time.Location) (*time.Time, error) {
+	s := strings.TrimSpace(r.str(name))
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("DateParseErr: %s %q is not a date", name, s)
+}
+
+// money reads the price field in the specs price format, a price that wasn't sent is free
+// and the currency falls back to the specs currency
+func (r *record) money(s *Spec) (koala.Money, error) {
+	cur := r.str("currency")
+	if cur == "" {
+		cur = s.Currency
+	}
+
+	amount, hit := r.text("price")
+	if !hit {
+		return koala.NewMoney(0, cur), nil
+	}
+
+	if s.Prices == PricesDecimal {
+		return koala.ParseMoney(amount, cur)
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(amount), 10, 64)
+	if err != nil {
+		return koala.Money{}, fmt.Errorf("price %q is not a whole number of minor units", amount)
+	}
+
+	return koala.NewMoney(n, cur), nil
+}
+
+// handoffMode normalizes an hours type, a type we don't know is kept as is so no hours are silently dropped
+func handoffMode(s string) koala.HandoffMode {
+	if s == "" {
+		return ""
+	}
+
+	if m, err := koala.ParseHandoffMode(s); err == nil {
+		return m
+	}
+
+	return koala.HandoffMode(strings.ToLower(s))
+}
+
+// handoffOrder is the order we list a locations modes in so the output doesn't depend on map order
+var handoffOrder = koala.HandoffModes{koala.HandoffPickup, koala.HandoffCurbside, koala.HandoffDelivery, koala.HandoffDriveThru, koala.HandoffDineIn}
+
+// handoffModes parses a list of mode names, names we don't know are kept as is
+func handoffModes(names []string) koala.HandoffModes {
+	modes := koala.HandoffModes{}
+	for _, n := range names {
+		modes = append(modes, handoffMode(n))
+	}
+
+	return modes
+}
+
+// locations returns a record for every location in the document
+func locations(s *Spec, doc interface{}) []*record {
+	var out []*record
+	for _, node := range s.Location.selectPath.Eval(doc) {
+		out = append(out, &record{node: node, fields: s.Location.Fields})
+	}
+
+	return out
+}
+
+// createProviderInfo finds the location with the ID in the document and maps it,
+// with no match in the spec the first location is used
+// It is nil when the location isn't in the document so we never serve an empty location
+// The timezone is the one the upstream sends, then the one the provider was given and last the specs
+func createProviderInfo(s *Spec, doc interface{}, ID, timezone string) (*koala.ProviderInfo, error) {
+	ls := s.Location
+
+	var loc *record
+	for _, r := range locations(s, doc) {
+		if ls.Match != "" {
+			if v, hit := ls.matchPath.First(r.node); !hit || toString(v) != ID {
+				continue
+			}
+		}
+		loc = r
+		break
+	}
+
+	if loc == nil {
+		return nil, nil
+	}
+
+	info := &koala.ProviderInfo{}
+	var err error
+	info.ID = loc.str("id")
+	info.Name = loc.str("name")
+	info.StreetAddress = loc.str("street_address")
+	info.City = loc.str("city")
+	info.State = loc.str("state")
+	info.Country = loc.str("country")
+	info.Zip = loc.str("zip")
+	info.Telephone = loc.str("telephone")
+	if info.Longitude, err = loc.float("longitude"); err != nil {
+		return nil, err
+	}
+	if info.Latitude, err = loc.float("latitude"); err != nil {
+		return nil, err
+	}
+
+	info.Timezone = loc.str("timezone")
+	if info.Timezone == "" {
+		info.Timezone = timezone
+	}
+	if info.Timezone == "" {
+		info.Timezone = s.Timezone
+	}
+
+	for _, h := range ls.Hours.each(loc.node) {
+		hour := &koala.ProviderHour{Type: handoffMode(h.str("type"))}
+		if hour.DayOfWeek, err = h.weekday("day"); err != nil {
+			return nil, err
+		}
+		if hour.Opens, err = h.timeOfDay("opens"); err != nil {
+			return nil, err
+		}
+		if hour.Closes, err = h.timeOfDay("closes"); err != nil {
+			return nil, err
+		}
+		info.StoreHours = append(info.StoreHours, hour)
+	}
+
+	// closures and special hours for a single date, an override without a date can't be placed so it is left out
+	for _, o := range ls.Overrides.each(loc.node) {
+		// only the calendar date is kept so the zone it is read in doesn't matter
+		date, err := o.date("date", time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		if date == nil {
+			continue
+		}
+
+		override := &koala.OverrideHour{Type: handoffMode(o.str("type")), Date: date.Format(koala.DateLayout)}
+		if override.Opens, err = o.timeOfDay("opens"); err != nil {
+			return nil, err
+		}
+		if override.Closes, err = o.timeOfDay("closes"); err != nil {
+			return nil, err
+		}
+		info.OverrideHours = append(info.OverrideHours, override)
+	}
+
+	info.HandoffModes = createHandoffModes(ls.Handoff, loc)
+	info.PaymentMethods = loc.texts("payment_methods")
+
+	return info, nil
+}
+
+// createHandoffModes lists the modes the location supports, it is empty when the spec says nothing about them
+func createHandoffModes(h *HandoffSpec, loc *record) koala.HandoffModes {
+	listed := loc.texts("handoff_modes")
+	if h == nil && len(listed) == 0 {
+		return nil
+	}
+
+	supported := handoffModes(listed)
+	if h != nil {
+		supported = append(supported, handoffModes(h.Always)...)
+		for mode, path := range h.flags {
+			if v, hit := path.First(loc.node); hit && isTrue(toString(v)) {
+				supported = append(supported, mode)
+			}
+		}
+	}
+
+	modes := koala.HandoffModes{}
+	for _, m := range handoffOrder {
+		if supported.Has(m) {
+			modes = append(modes, m)
+		}
+	}
+	// anything we don't know goes on the end so it isn't lost
+	for _, m := range supported {
+		if !handoffOrder.Has(m) && !modes.Has(m) {
+			modes = append(modes, m)
+		}
+	}
+
+	return modes
+}
+
+// createMenu maps the menu document, categories and modifier lists are collected
+// in a first pass because an item can come before the things it points at
+// The locations handoff modes are needed for items that only list the modes they can't do
+// and its timezone for availability dates sent without one
+func createMenu(s *Spec, doc interface{}, modes koala.HandoffModes, loc *time.Location) (*koala.Menu, error) {
+	ms := s.Menu
+
+	cats := make(map[string]*koala.Category)
+	for _, c := range ms.Categories.each(doc) {
+		cat := &koala.Category{ID: c.str("id"), Name: c.str("name"), Disabled: c.bool("disabled")}
+		cats[cat.ID] = cat
+	}
+
+	lists := make(map[string]*modifierList)
+	if ms.Modifiers != nil {
+		for _, m := range ms.Modifiers.each(doc) {
+			list, err := createModifierList(s, ms.Modifiers, m)
+			if err != nil {
+				return nil, err
+			}
+			lists[list.group.ID] = list
+		}
+	}
+
+	// items are either all over the document or inside their category
+	type found struct {
+		r          *record
+		categoryID string
+	}
+	var records []found
+	if ms.Items.InCategories {
+		for _, c := range ms.Categories.each(doc) {
+			for _, r := range ms.Items.each(c.node) {
+				records = append(records, found{r, c.str("id")})
+			}
+		}
+	} else {
+		for _, r := range ms.Items.each(doc) {
+			records = append(records, found{r: r})
+		}
+	}
+
+	items := []*koala.MenuItem{}
+	for _, f := range records {
+		mi, err := createItem(s, f.r, cats, lists, modes, loc)
+		if err != nil {
+			return nil, fmt.Errorf("item %s %w", f.r.str("id"), err)
+		}
+		if mi.CategoryID == "" && f.categoryID != "" {
+			mi.CategoryID = f.categoryID
+			if c, hit := cats[f.categoryID]; hit {
+				cat := *c
+				mi.Category = &cat
+			}
+		}
+		items = append(items, mi)
+	}
+
+	return &koala.Menu{MenuItems: items}, nil
+}
+
+// ruleInput is a groups selection settings as the upstream sent them,
+// a modifier list keeps these so an item can override the limits before they are normalized
+type ruleInput struct {
+	selectionType string
+	mandatory     bool
+	min, max      int
+	minSet        bool
+	maxSet        bool
+	minQuantity   int
+	maxQuantity   int
+}
+
+func readRules(r *record) (ruleInput, error) {
+	in := ruleInput{selectionType: r.str("selection_type"), mandatory: r.bool("mandatory")}
+
+	var err error
+	if in.min, in.minSet, err = r.int("min_selects"); err != nil {
+		return in, err
+	}
+	if in.max, in.maxSet, err = r.int("max_selects"); err != nil {
+		return in, err
+	}
+	if in.minQuantity, _, err = r.int("min_quantity"); err != nil {
+		return in, err
+	}
+	if in.maxQuantity, _, err = r.int("max_quantity"); err != nil {
+		return in, err
+	}
+
+	return in, nil
+}
+
+// override replaces the limits with the ones an item sets
+func (in ruleInput) override(r *record) (ruleInput, error) {
+	min, minSet, err := r.int("min_selects")
+	if err != nil {
+		return in, err
+	}
+	max, maxSet, err := r.int("max_selects")
+	if err != nil {
+		return in, err
+	}
+
+	if minSet {
+		in.min, in.minSet = min, true
+	}
+	if maxSet {
+		in.max, in.maxSet = max, true
+	}
+
+	return in, nil
+}
+
+// rules normalizes the selection settings
+// A mandatory group without explicit limits is a pick one, a SINGLE group without a max is a pick at most one
+// and a group with a minimum is mandatory however the upstream flagged it
+func (in ruleInput) rules() koala.SelectionRules {
+	r := koala.SelectionRules{
+		Mandatory:   in.mandatory,
+		MinQuantity: in.minQuantity,
+		MaxQuantity: in.maxQuantity,
+	}
+	if r.MinQuantity < 0 {
+		r.MinQuantity = 0
+	}
+	if r.MaxQuantity < 0 {
+		r.MaxQuantity = 0
+	}
+
+	single := strings.EqualFold(in.selectionType, koala.SelectionSingle)
+
+	switch {
+	case in.minSet:
+		r.MinSelects = in.min
+	case in.mandatory:
+		r.MinSelects = 1
+	}
+
+	switch {
+	case in.maxSet && in.max > 0:
+		r.MaxSelects = in.max
+	case !in.maxSet && in.mandatory:
+		r.MaxSelects = 1
+	case single:
+		r.MaxSelects = 1
+	}
+
+	if r.Mandatory && r.MinSelects < 1 {
+		r.MinSelects = 1
+	}
+	r.Mandatory = r.MinSelects > 0
+
+	r.SelectionType = koala.SelectionMultiple
+	if single || r.MaxSelects == 1 {
+		r.SelectionType = koala.SelectionSingle
+	}
+
+	return r
+}
+
+// modifierList is a modifier list items point at, along with its settings before an item overrides them
+type modifierList struct {
+	group *koala.ModifierGroup
+	in    ruleInput
+}
+
+func createModifierList(s *Spec, gs *GroupSpec, m *record) (*modifierList, error) {
+	in, err := readRules(m)
+	if err != nil {
+		return nil, fmt.Errorf("group %s %w", m.str("id"), err)
+	}
+
+	g, err := groupWithRules(s, gs, m, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return &modifierList{group: g, in: in}, nil
+}
+
+// createGroup maps a group and its options, it calls itself for the groups each option opens
+func createGroup(s *Spec, gs *GroupSpec, m *record) (*koala.ModifierGroup, error) {
+	in, err := readRules(m)
+	if err != nil {
+		return nil, fmt.Errorf("group %s %w", m.str("id"), err)
+	}
+
+	return groupWithRules(s, gs, m, in)
+}
+
+// groupWithRules is createGroup for selection settings that were already read
+func groupWithRules(s *Spec, gs *GroupSpec, m *record, in ruleInput) (*koala.ModifierGroup, error) {
+	g := &koala.ModifierGroup{
+		ID:       m.str("id"),
+		Name:     m.str("name"),
+		Disabled: m.bool("disabled"),
+		Rules:    in.rules(),
+	}
+
+	options := gs.Options.each(m.node)
+	g.Options = make([]*koala.ModifierOption, 0, len(options))
+	for _, o := range options {
+		cost, err := o.money(s)
+		if err != nil {
+			return nil, fmt.Errorf("option %s %w", o.str("id"), err)
+		}
+
+		opt := &koala.ModifierOption{
+			ID:       o.str("id"),
+			Name:     o.str("name"),
+			Disabled: o.bool("disabled"),
+			Default:  o.bool("default"),
+			Cost:     cost,
+		}
+
+		if gs.Nested != "" {
+			for _, node := range gs.nestedPath.Eval(o.node) {
+				nested, err := createGroup(s, gs, &record{node: node, fields: gs.Fields})
+				if err != nil {
+					return nil, err
+				}
+				opt.Modifiers = append(opt.Modifiers, nested)
+			}
+		}
+
+		g.Options = append(g.Options, opt)
+	}
+
+	return g, nil
+}
+
+func createItem(s *Spec, r *record, cats map[string]*koala.Category, lists map[string]*modifierList, modes koala.HandoffModes, loc *time.Location) (*koala.MenuItem, error) {
+	is := s.Menu.Items
+
+	mi := &koala.MenuItem{
+		ID:          r.str("id"),
+		Name:        r.str("name"),
+		Description: r.str("description"),
+		Disabled:    r.bool("disabled"),
+		CategoryID:  r.str("category_id"),
+	}
+	// items get their own copy so they don't share a pointer
+	if c, hit := cats[mi.CategoryID]; hit {
+		cat := *c
+		mi.Category = &cat
+	}
+
+	var err error
+	for _, v := range is.Variations.each(r.node) {
+		price, err := v.money(s)
+		if err != nil {
+			return nil, err
+		}
+		mi.Variations = append(mi.Variations, &koala.Variation{
+			ID:    v.str("id"),
+			Name:  v.str("name"),
+			SKU:   v.str("sku"),
+			Price: price,
+		})
+	}
+
+	if is.Modifiers != nil {
+		for _, ref := range is.Modifiers.each(r.node) {
+			mi.ModifierListID = ref.str("id")
+			list, hit := lists[mi.ModifierListID]
+			if !hit {
+				continue
+			}
+
+			g, err := applyOverrides(list, ref, is.Modifiers.Defaults)
+			if err != nil {
+				return nil, err
+			}
+			mi.Modifiers = append(mi.Modifiers, g)
+		}
+	}
+
+	if is.Groups != nil {
+		for _, gr := range is.Groups.each(r.node) {
+			g, err := createGroup(s, is.Groups, gr)
+			if err != nil {
+				return nil, err
+			}
+			mi.Modifiers = append(mi.Modifiers, g)
+		}
+	}
+
+	if mi.Availability, err = createAvailability(is.Availability, r, loc); err != nil {
+		return nil, err
+	}
+
+	// items either list the modes they support or the ones they don't out of what the location supports
+	if _, hit := r.fields["handoff_modes"]; hit {
+		mi.HandoffModes = handoffModes(r.texts("handoff_modes"))
+	}
+	if _, hit := r.fields["unavailable_handoff_modes"]; hit {
+		if mi.HandoffModes == nil {
+			mi.HandoffModes = modes
+		}
+		mi.HandoffModes = mi.HandoffModes.Without(handoffModes(r.texts("unavailable_handoff_modes"))...)
+	}
+
+	return mi, nil
+}
+
+// createAvailability maps when the item can be ordered,
+// it is nil when the item has no dates and no times since it can then be ordered any time
+func createAvailability(as *AvailabilitySpec, item *record, loc *time.Location) (*koala.Availability, error) {
+	if as == nil {
+		return nil, nil
+	}
+
+	nodes := as.Collection.each(item.node)
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	r := nodes[0]
+
+	a := &koala.Availability{}
+	var err error
+	if a.StartDate, err = r.date("start_date", loc); err != nil {
+		return nil, err
+	}
+	if a.EndDate, err = r.date("end_date", loc); err != nil {
+		return nil, err
+	}
+
+	for _, t := range as.Times.each(r.node) {
+		tr := &koala.TimeRange{}
+		if tr.From.Day, err = t.weekday("from_day"); err != nil {
+			return nil, err
+		}
+		if tr.From.Time, err = t.timeOfDay("from_time"); err != nil {
+			return nil, err
+		}
+		if tr.To.Day, err = t.weekday("to_day"); err != nil {
+			return nil, err
+		}
+		if tr.To.Time, err = t.timeOfDay("to_time"); err != nil {
+			return nil, err
+		}
+		a.Times = append(a.Times, tr)
+	}
+
+	if a.StartDate == nil && a.EndDate == nil && len(a.Times) == 0 {
+		return nil, nil
+	}
+
+	return a, nil
+}
+
+// applyOverrides copies the list for one item and applies the limits and option defaults the item sets,
+// the copy keeps overrides from leaking to the other items using the list
+func applyOverrides(list *modifierList, ref *record, defaults *Collection) (*koala.ModifierGroup, error) {
+	g := *list.group
+	g.Options = make([]*koala.ModifierOption, 0, len(list.group.Options))
+	for _, o := range list.group.Options {
+		opt := *o
+		g.Options = append(g.Options, &opt)
+	}
+
+	overrides := make(map[string]bool)
+	for _, d := range defaults.each(ref.node) {
+		overrides[d.str("id")] = d.bool("default")
+	}
+	for _, o := range g.Options {
+		if d, hit := overrides[o.ID]; hit {
+			o.Default = d
+		}
+	}
+
+	in, err := list.in.override(ref)
+	if err != nil {
+		return nil, err
+	}
+	g.Rules = in.rules()
+
+	return &g, nil
+}
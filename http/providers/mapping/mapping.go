@@ -0,0 +1,245 @@
+package mapping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	koala "github.com/ko1eda/apiaggregator"
+	"github.com/ko1eda/apiaggregator/http"
+)
+
+// we do a runtime check to ensure our item implenets our AsyncProviderService
+// and can serve every location in an upstream that lists many
+var _ http.MultiLocationProvider = &Provider{}
+
+// Provider is a generic provider driven by a Spec, it fetches the upstream documents
+// and maps them into our types the way the spec says
+type Provider struct {
+	LocationID string
+	MenuURL    string
+	// leave empty when the location is in the menu document
+	LocationURL string
+	// used when neither the upstream nor the spec has a timezone
+	Timezone string
+	spec     *Spec
+	client   http.HttpGetter
+}
+
+// Return a new mapping provider for the spec with variadic modifier params
+func NewProvider(c http.HttpGetter, spec *Spec, opts ...func(*Provider)) *Provider {
+	p := &Provider{client: c, spec: spec}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithLocationID sets the location ID we pick out of the location document
+func WithLocationID(ID string) func(*Provider) {
+	return func(p *Provider) {
+		p.LocationID = ID
+	}
+}
+
+// WithMenuURL sets the upstream endpoint we fetch the menu from
+func WithMenuURL(url string) func(*Provider) {
+	return func(p *Provider) {
+		p.MenuURL = url
+	}
+}
+
+// WithLocationURL sets the upstream endpoint we fetch the location from
+func WithLocationURL(url string) func(*Provider) {
+	return func(p *Provider) {
+		p.LocationURL = url
+	}
+}
+
+// WithTimezone sets the timezone of the location for upstreams that don't send one
+func WithTimezone(tz string) func(*Provider) {
+	return func(p *Provider) {
+		p.Timezone = tz
+	}
+}
+
+// locationURL is where the location lives, the menu document when no location url is set
+func (p *Provider) locationURL() string {
+	if p.LocationURL == "" {
+		return p.MenuURL
+	}
+
+	return p.LocationURL
+}
+
+// fetch gets the url with our client and decodes the body into a generic document for our paths to walk
+// Any non 2xx response is treated as an error, the body is always closed
+// Errors have a koala error kind so the server can tell a slow upstream from a broken one
+func (p *Provider) fetch(ctx context.Context, url string) (interface{}, error) {
+	resp, err := p.client.Get(ctx, url)
+	if err != nil {
+		return nil, http.UpstreamError(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, http.StatusError(resp.StatusCode, url)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, http.UpstreamError(fmt.Errorf("MappingReadErr: Could not read %s %w", url, err))
+	}
+
+	return p.decode(b, url)
+}
+
+// decode turns the body into maps, slices and scalars in the specs format
+// Numbers are kept as json.Number so prices and IDs aren't rounded through a float
+func (p *Provider) decode(b []byte, url string) (interface{}, error) {
+	if p.spec.Format == FormatXML {
+		doc, err := decodeXML(b)
+		if err != nil {
+			return nil, koala.NewError("XmlDecodeErr", koala.ErrDecode, fmt.Errorf("Could not decode %s %w", url, err))
+		}
+		return doc, nil
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, koala.NewError("JsonDecodeErr", koala.ErrDecode, fmt.Errorf("Could not decode %s %w", url, err))
+	}
+
+	return doc, nil
+}
+
+// providerInfo maps the location out of its document,
+// a spec without a match has the one location per document so it has to be the one we were set up with
+func (p *Provider) providerInfo(doc interface{}) (*koala.ProviderInfo, error) {
+	info, err := createProviderInfo(p.spec, doc, p.LocationID, p.Timezone)
+	if err != nil {
+		return nil, koala.NewError("LocationMapErr", koala.ErrDecode, fmt.Errorf("%s %w", p.spec.Name, err))
+	}
+
+	if info == nil {
+		return nil, koala.NewError("LocationNotFoundErr", koala.ErrNotFound, fmt.Errorf("location %s is not in %s", p.LocationID, p.locationURL()))
+	}
+
+	if p.spec.Location.Match == "" && p.LocationID != "" && info.ID != p.LocationID {
+		return nil, koala.NewError("LocationMismatchErr", koala.ErrLocationMismatch, fmt.Errorf("asked for location %s but %s is for location %s", p.LocationID, p.locationURL(), info.ID))
+	}
+
+	return info, nil
+}
+
+// Locations lists the ID of every location the spec selects in the location document
+func (p *Provider) Locations(ctx context.Context) ([]string, error) {
+	doc, err := p.fetch(ctx, p.locationURL())
+	if err != nil {
+		return nil, fmt.Errorf("LocationFetchErr: Could not get locations %w", err)
+	}
+
+	IDs := []string{}
+	for _, r := range locations(p.spec, doc) {
+		// with a match path the ID is what we match on, it can differ from the id field we output
+		ID := r.str("id")
+		if p.spec.Location.Match != "" {
+			v, _ := p.spec.Location.matchPath.First(r.node)
+			ID = toString(v)
+		}
+		if ID != "" {
+			IDs = append(IDs, ID)
+		}
+	}
+
+	return IDs, nil
+}
+
+// ForLocation returns a copy of the provider for another location in the same upstream,
+// there is one menu url per entry so every location gets the same menu and only the provider info differs
+func (p *Provider) ForLocation(ID string) http.AsyncProvider {
+	c := *p
+	c.LocationID = ID
+
+	return &c
+}
+
+// Get the provider info
+func (p *Provider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	doc, err := p.fetch(ctx, p.locationURL())
+	if err != nil {
+		return nil, fmt.Errorf("LocationFetchErr: Could not get location %w", err)
+	}
+
+	return p.providerInfo(doc)
+}
+
+// Get the full menu, the menu and location are fetched at the same time
+// unless they are the same document, then it is only fetched once
+func (p *Provider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	type result struct {
+		doc interface{}
+		err error
+	}
+
+	// buffered so our goroutines never block even if we return early
+	menuChan := make(chan result, 1)
+	locationChan := make(chan result, 1)
+
+	go func() {
+		doc, err := p.fetch(ctx, p.MenuURL)
+		menuChan <- result{doc, err}
+	}()
+
+	if p.locationURL() != p.MenuURL {
+		go func() {
+			doc, err := p.fetch(ctx, p.locationURL())
+			locationChan <- result{doc, err}
+		}()
+	}
+
+	var menuDoc, locationDoc interface{}
+	for menuDoc == nil || locationDoc == nil {
+		select {
+		case r := <-menuChan:
+			if r.err != nil {
+				return nil, fmt.Errorf("MenuFetchErr: Could not fetch menu: %w", r.err)
+			}
+			menuDoc = r.doc
+			if p.locationURL() == p.MenuURL {
+				locationDoc = r.doc
+			}
+		case r := <-locationChan:
+			if r.err != nil {
+				return nil, fmt.Errorf("LocationFetchErr: Could not fetch location: %w", r.err)
+			}
+			locationDoc = r.doc
+		case <-ctx.Done():
+			return nil, fmt.Errorf("MenuFetchErr: %w", http.UpstreamError(ctx.Err()))
+		}
+	}
+
+	// the location goes first since items can only list the modes they don't support
+	info, err := p.providerInfo(locationDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := info.Location()
+	if err != nil {
+		return nil, koala.NewError("MenuMapErr", koala.ErrDecode, fmt.Errorf("%s %w", p.spec.Name, err))
+	}
+
+	menu, err := createMenu(p.spec, menuDoc, info.HandoffModes, loc)
+	if err != nil {
+		return nil, koala.NewError("MenuMapErr", koala.ErrDecode, fmt.Errorf("%s %w", p.spec.Name, err))
+	}
+	menu.ProviderInfo = info
+
+	return menu, nil
+}
@@ -0,0 +1,140 @@
+package mapping_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+	"github.com/ko1eda/apiaggregator/http"
+	"github.com/ko1eda/apiaggregator/http/providers/mapping"
+	"github.com/ko1eda/apiaggregator/http/providertest"
+)
+
+// The specs for the eatery and the grill have to give exactly what the hand written providers do,
+// so they are checked against the same expected files
+func TestSpecs(t *testing.T) {
+	cases := []struct {
+		spec string
+		opts []func(*mapping.Provider)
+	}{
+		{
+			spec: "koala-json-eatery",
+			opts: []func(*mapping.Provider){
+				mapping.WithLocationID("2"),
+				mapping.WithMenuURL("file:///json-eatery-menu.json"),
+				mapping.WithLocationURL("file:///json-eatery-location.json"),
+			},
+		},
+		{
+			spec: "koala-xml-grill",
+			opts: []func(*mapping.Provider){
+				mapping.WithLocationID("1"),
+				mapping.WithMenuURL("file:///xml-grill-data.xml"),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		spec, err := mapping.LoadSpec("../../../mappings/" + tc.spec + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := tc.opts
+		c := providertest.Case{
+			Name: "mapping-" + tc.spec,
+			// the hand written providers own these, -update from here never rewrites them
+			Golden:   tc.spec,
+			Fixtures: "../../../goldenfiles",
+			Expected: "../../../goldenfiles/expected",
+			New: func(c http.HttpGetter) http.AsyncProvider {
+				return mapping.NewProvider(c, spec, opts...)
+			},
+		}
+
+		providertest.Run(t, c)
+		providertest.RunFaults(t, c)
+	}
+}
+
+func TestLocationNotFound(t *testing.T) {
+	spec, err := mapping.LoadSpec("../../../mappings/koala-json-eatery.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := mapping.NewProvider(
+		http.NewClient(http.WithFileRoot("../../../goldenfiles")),
+		spec,
+		mapping.WithLocationID("99"),
+		mapping.WithMenuURL("file:///json-eatery-menu.json"),
+		mapping.WithLocationURL("file:///json-eatery-locations.json"),
+	)
+
+	if info, err := p.GetProviderInfo(context.Background()); !errors.Is(err, koala.ErrNotFound) {
+		t.Errorf("GetProviderInfo got %+v and %v, want koala.ErrNotFound", info, err)
+	}
+	if _, err := p.GetFullMenu(context.Background()); !errors.Is(err, koala.ErrNotFound) {
+		t.Errorf("GetFullMenu got %v, want koala.ErrNotFound", err)
+	}
+
+	IDs, err := p.Locations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(IDs, want) {
+		t.Errorf("Locations got %v, want %v", IDs, want)
+	}
+}
+
+// dates without an offset are on the locations wall clock
+func TestAvailabilityDates(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	spec, err := mapping.LoadSpec("../../../mappings/koala-xml-grill.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile("../../../goldenfiles/xml-grill-data.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := strings.Replace(string(b), `<startDate xsi:nil="true" />`, `<startDate>2020-12-01T00:00:00</startDate>`, 1)
+	doc = strings.Replace(doc, `<endDate xsi:nil="true" />`, `<endDate>2020-12-31T00:00:00Z</endDate>`, 1)
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "xml-grill-data.xml"), []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := mapping.NewProvider(
+		http.NewClient(http.WithFileRoot(dir)),
+		spec,
+		mapping.WithLocationID("1"),
+		mapping.WithMenuURL("file:///xml-grill-data.xml"),
+		mapping.WithTimezone("America/Chicago"),
+	)
+	menu, err := p.GetFullMenu(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := menu.MenuItems[0].Availability
+	if want := time.Date(2020, 12, 1, 0, 0, 0, 0, chicago); a.StartDate == nil || !a.StartDate.Equal(want) {
+		t.Errorf("start date is %v, want %v", a.StartDate, want)
+	}
+	// a date with an offset keeps it
+	if want := time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC); a.EndDate == nil || !a.EndDate.Equal(want) {
+		t.Errorf("end date is %v, want %v", a.EndDate, want)
+	}
+}
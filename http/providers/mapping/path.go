@@ -0,0 +1,258 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled selector into a decoded document, it is a small subset of JSONPath
+// Keys are separated by dots and each key can be followed by any number of brackets
+//
+//	a.b          the b field of a
+//	items[*]     every element of the items array
+//	items[0]     the first element
+//	objects[?type=ITEM]  only the elements whose type field is ITEM, the field can be a path of its own
+//
+// A key used on an array is applied to every element so locations.id is every locations id.
+// An empty path or $ is the node itself
+type Path []*segment
+
+// segment is a single key and the brackets that follow it
+type segment struct {
+	key     string
+	selects []*selector
+}
+
+// selector is one bracket, exactly one of its fields is used
+type selector struct {
+	all    bool
+	index  int
+	filter Path
+	equals string
+}
+
+// ParsePath compiles a selector EX: objects[?type=ITEM].item_data.name
+func ParsePath(s string) (Path, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "$"), ".")
+	if s == "" {
+		return Path{}, nil
+	}
+
+	parts, err := splitPath(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := make(Path, 0, len(parts))
+	for _, part := range parts {
+		seg, err := parseSegment(part)
+		if err != nil {
+			return nil, fmt.Errorf("PathParseErr: %q %w", s, err)
+		}
+		p = append(p, seg)
+	}
+
+	return p, nil
+}
+
+// splitPath splits on dots that aren't inside brackets so filters can hold paths of their own
+func splitPath(s string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("PathParseErr: %q has an unmatched ]", s)
+			}
+		case '.':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("PathParseErr: %q has an unmatched [", s)
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+// parseSegment reads a key and its brackets EX: objects[?type=ITEM][0]
+func parseSegment(s string) (*segment, error) {
+	i := strings.Index(s, "[")
+	if i < 0 {
+		i = len(s)
+	}
+
+	seg := &segment{key: s[:i]}
+	if seg.key == "" && i == len(s) {
+		return nil, fmt.Errorf("has an empty key")
+	}
+
+	rest := s[i:]
+	for rest != "" {
+		end := matchingBracket(rest)
+		if rest[0] != '[' || end < 0 {
+			return nil, fmt.Errorf("has a bad bracket near %q", rest)
+		}
+
+		sel, err := parseSelector(rest[1:end])
+		if err != nil {
+			return nil, err
+		}
+		seg.selects = append(seg.selects, sel)
+		rest = rest[end+1:]
+	}
+
+	return seg, nil
+}
+
+// matchingBracket returns the index of the ] closing the [ at the start of s
+func matchingBracket(s string) int {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// parseSelector reads what is between the brackets, * or an index or ?path=value
+func parseSelector(s string) (*selector, error) {
+	switch {
+	case s == "*":
+		return &selector{all: true}, nil
+	case strings.HasPrefix(s, "?"):
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("filter %q needs a value EX: [?type=ITEM]", s)
+		}
+		filter, err := ParsePath(s[1:eq])
+		if err != nil {
+			return nil, err
+		}
+		return &selector{filter: filter, equals: s[eq+1:]}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%q is not *, an index or a filter", s)
+	}
+
+	return &selector{index: n}, nil
+}
+
+// Eval returns every node the path selects from v, it is empty when nothing matches
+func (p Path) Eval(v interface{}) []interface{} {
+	nodes := []interface{}{v}
+	for _, seg := range p {
+		nodes = seg.eval(nodes)
+	}
+
+	return nodes
+}
+
+// First returns the first node the path selects and whether there was one
+func (p Path) First(v interface{}) (interface{}, bool) {
+	nodes := p.Eval(v)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+
+	return nodes[0], true
+}
+
+func (s *segment) eval(nodes []interface{}) []interface{} {
+	if s.key != "" {
+		nodes = lookup(nodes, s.key)
+	}
+
+	for _, sel := range s.selects {
+		nodes = sel.eval(nodes)
+	}
+
+	return nodes
+}
+
+// lookup gets the key from every object, arrays are looked into so a key on a list applies to its elements
+func lookup(nodes []interface{}, key string) []interface{} {
+	var out []interface{}
+	for _, n := range nodes {
+		switch v := n.(type) {
+		case map[string]interface{}:
+			if c, hit := v[key]; hit && c != nil {
+				out = append(out, c)
+			}
+		case []interface{}:
+			out = append(out, lookup(v, key)...)
+		}
+	}
+
+	return out
+}
+
+func (s *selector) eval(nodes []interface{}) []interface{} {
+	var out []interface{}
+	for _, n := range nodes {
+		// a single node is treated like an array of one so documents that
+		// only sometimes send a list (EX: one xml element) still work
+		list, ok := n.([]interface{})
+		if !ok {
+			list = []interface{}{n}
+		}
+
+		switch {
+		case s.all:
+			out = append(out, list...)
+		case s.filter != nil:
+			for _, e := range list {
+				if v, hit := s.filter.First(e); hit && toString(v) == s.equals {
+					out = append(out, e)
+				}
+			}
+		case s.index < len(list):
+			out = append(out, list[s.index])
+		}
+	}
+
+	return out
+}
+
+// toString is the text form of a scalar node, objects and arrays have none
+// except for an xml element which is its text and a list of one which is that one node
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		return toString(t[textKey])
+	case []interface{}:
+		if len(t) == 1 {
+			return toString(t[0])
+		}
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+
+	return ""
+}
@@ -0,0 +1,394 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+// The document formats a spec can read
+const (
+	FormatJSON = "json"
+	// elements are read into nodes with their attributes as @name and their text as #text
+	// EX: <product id="1"><name>Wings</name></product> is selected with @id and name.#text or just name
+	FormatXML = "xml"
+)
+
+// How an upstream writes its prices
+const (
+	// PricesMinor is a whole number of minor units EX: 499 is $4.99
+	PricesMinor = "minor"
+	// PricesDecimal is a decimal in major units EX: 4.99
+	PricesDecimal = "decimal"
+)
+
+// Spec describes how to turn an upstream document into our menu and provider info
+// so onboarding a new source is writing one of these instead of a new provider package
+// See mappings/koala-json-eatery.json and mappings/koala-xml-grill.json for our two providers written as specs
+type Spec struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// currency for prices that don't send one
+	Currency string `json:"currency"`
+	Prices   string `json:"prices"`
+	// timezone for locations that don't send one
+	Timezone string        `json:"timezone,omitempty"`
+	Location *LocationSpec `json:"location"`
+	Menu     *MenuSpec     `json:"menu"`
+}
+
+// LocationSpec finds the location in the location document
+// Select picks every location and Match is the field compared with the providers location ID,
+// leave Match out when the document only ever holds one location
+type LocationSpec struct {
+	Select    string            `json:"select"`
+	Match     string            `json:"match,omitempty"`
+	Fields    map[string]*Field `json:"fields"`
+	Hours     *Collection       `json:"hours,omitempty"`
+	Overrides *Collection       `json:"overrides,omitempty"`
+	Handoff   *HandoffSpec      `json:"handoff,omitempty"`
+
+	selectPath Path
+	matchPath  Path
+}
+
+// HandoffSpec builds the locations handoff modes from flags EX: supportsdispatch="true" is delivery
+// Always lists modes every location supports, Flags maps a mode to a path that is true when it is supported
+// A location can also list its modes directly with the handoff_modes field
+type HandoffSpec struct {
+	Always []string          `json:"always,omitempty"`
+	Flags  map[string]string `json:"flags,omitempty"`
+
+	flags map[koala.HandoffMode]Path
+}
+
+// MenuSpec maps the menu document
+// Categories and modifier lists are collected first so items can point at them by ID
+type MenuSpec struct {
+	Categories *Collection `json:"categories,omitempty"`
+	// modifier lists items point at by ID
+	Modifiers *GroupSpec `json:"modifiers,omitempty"`
+	Items     *ItemSpec  `json:"items"`
+}
+
+// GroupSpec maps modifier groups and the options inside each one
+// Nested is the path from an option to the groups it opens, they are mapped with this same spec to any depth
+type GroupSpec struct {
+	Collection
+	Options *Collection `json:"options"`
+	Nested  string      `json:"nested,omitempty"`
+
+	nestedPath Path
+}
+
+// ItemSpec maps menu items, each part below is selected from inside the item
+type ItemSpec struct {
+	Collection
+	// select the items inside every category instead of the whole document, each item then belongs to its category
+	InCategories bool `json:"in_categories,omitempty"`
+	// the modifier lists the item uses, id points at a list from MenuSpec.Modifiers
+	Modifiers *ItemModifierSpec `json:"modifiers,omitempty"`
+	// modifier groups written inside the item itself
+	Groups       *GroupSpec        `json:"groups,omitempty"`
+	Variations   *Collection       `json:"variations,omitempty"`
+	Availability *AvailabilitySpec `json:"availability,omitempty"`
+}
+
+// AvailabilitySpec selects when an item can be ordered, an item with no dates and no times is always available
+type AvailabilitySpec struct {
+	Collection
+	Times *Collection `json:"times,omitempty"`
+}
+
+// ItemModifierSpec is an items reference to a modifier list along with any limits and defaults the item overrides
+type ItemModifierSpec struct {
+	Collection
+	Defaults *Collection `json:"defaults,omitempty"`
+}
+
+// Collection selects a list of nodes and maps the fields of each one
+// Any node where Skip is true is left out EX: a deleted variation
+type Collection struct {
+	Select string            `json:"select"`
+	Skip   string            `json:"skip,omitempty"`
+	Fields map[string]*Field `json:"fields"`
+
+	selectPath Path
+	skipPath   Path
+}
+
+// Field maps one of our fields from a path in the upstream node,
+// in a spec it can be written as just the path when it needs nothing else EX: "name": "item_data.name"
+// A field with only a default is a constant EX: {"default": "Regular"}
+type Field struct {
+	Path       string       `json:"path"`
+	Default    string       `json:"default,omitempty"`
+	Transforms []*Transform `json:"transforms,omitempty"`
+
+	path Path
+}
+
+func (f *Field) UnmarshalJSON(b []byte) error {
+	var path string
+	if err := json.Unmarshal(b, &path); err == nil {
+		f.Path = path
+		return nil
+	}
+
+	// an alias so we don't call ourselves forever
+	type field Field
+	return json.Unmarshal(b, (*field)(f))
+}
+
+// Transform changes a fields text before it is converted
+//
+//	upper, lower and trim take no args
+//	replace takes the old and new text EX: {"op": "replace", "args": ["_", " "]}
+//	map looks the text up in Values and leaves it as is when it isn't there
+type Transform struct {
+	Op     string            `json:"op"`
+	Args   []string          `json:"args,omitempty"`
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// apply runs the transform on s
+func (t *Transform) apply(s string) string {
+	switch t.Op {
+	case "upper":
+		return strings.ToUpper(s)
+	case "lower":
+		return strings.ToLower(s)
+	case "trim":
+		return strings.TrimSpace(s)
+	case "replace":
+		return strings.ReplaceAll(s, t.Args[0], t.Args[1])
+	case "map":
+		if v, hit := t.Values[s]; hit {
+			return v
+		}
+	}
+
+	return s
+}
+
+func (t *Transform) check() error {
+	switch t.Op {
+	case "upper", "lower", "trim", "map":
+	case "replace":
+		if len(t.Args) != 2 {
+			return fmt.Errorf("replace needs the old and new text as args")
+		}
+	default:
+		return fmt.Errorf("unknown transform %q, must be one of upper, lower, trim, replace or map", t.Op)
+	}
+
+	return nil
+}
+
+// The fields each part of a spec can map, anything else in a spec is a mistake
+var fieldNames = map[string][]string{
+	"location":       {"id", "name", "street_address", "city", "state", "country", "zip", "telephone", "longitude", "latitude", "timezone", "payment_methods", "handoff_modes"},
+	"hours":          {"day", "opens", "closes", "type"},
+	"overrides":      {"date", "opens", "closes", "type"},
+	"categories":     {"id", "name", "disabled"},
+	"groups":         {"id", "name", "disabled", "selection_type", "mandatory", "min_selects", "max_selects", "min_quantity", "max_quantity"},
+	"options":        {"id", "name", "disabled", "default", "price", "currency"},
+	"items":          {"id", "name", "description", "disabled", "category_id", "handoff_modes", "unavailable_handoff_modes"},
+	"item_modifiers": {"id", "min_selects", "max_selects"},
+	"defaults":       {"id", "default"},
+	"variations":     {"id", "name", "sku", "price", "currency"},
+	"availability":   {"start_date", "end_date"},
+	"times":          {"from_day", "from_time", "to_day", "to_time"},
+}
+
+// LoadSpec reads and checks the spec file at path
+func LoadSpec(path string) (*Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MappingSpecErr: Could not read %s %w", path, err)
+	}
+
+	return ParseSpec(b)
+}
+
+// ParseSpec reads a spec and compiles every path in it, a spec with a mistake in it is an error
+// here rather than a menu that is quietly missing fields
+func ParseSpec(b []byte) (*Spec, error) {
+	s := &Spec{}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("MappingSpecErr: Could not decode spec %w", err)
+	}
+
+	if err := s.compile(); err != nil {
+		return nil, fmt.Errorf("MappingSpecErr: %s %w", s.Name, err)
+	}
+
+	return s, nil
+}
+
+// compile fills in defaults, parses every path and checks the field names
+func (s *Spec) compile() error {
+	if s.Format == "" {
+		s.Format = FormatJSON
+	}
+	if s.Format != FormatJSON && s.Format != FormatXML {
+		return fmt.Errorf("unknown format %q, must be %s or %s", s.Format, FormatJSON, FormatXML)
+	}
+
+	if s.Prices == "" {
+		s.Prices = PricesMinor
+	}
+	if s.Prices != PricesMinor && s.Prices != PricesDecimal {
+		return fmt.Errorf("prices must be %s or %s", PricesMinor, PricesDecimal)
+	}
+
+	if s.Location == nil || s.Menu == nil || s.Menu.Items == nil {
+		return fmt.Errorf("location, menu and menu.items are required")
+	}
+
+	var err error
+	if s.Location.selectPath, err = ParsePath(s.Location.Select); err != nil {
+		return fmt.Errorf("location.select %w", err)
+	}
+	if s.Location.matchPath, err = ParsePath(s.Location.Match); err != nil {
+		return fmt.Errorf("location.match %w", err)
+	}
+	if err := compileFields("location", s.Location.Fields); err != nil {
+		return err
+	}
+	if err := s.Location.Handoff.compile(); err != nil {
+		return err
+	}
+
+	items := s.Menu.Items
+	if items.InCategories && s.Menu.Categories == nil {
+		return fmt.Errorf("items.in_categories needs menu.categories")
+	}
+
+	// every optional part of the spec by the name of the fields it allows
+	parts := []*part{
+		{"hours", s.Location.Hours},
+		{"overrides", s.Location.Overrides},
+		{"categories", s.Menu.Categories},
+		{"items", &items.Collection},
+		{"variations", items.Variations},
+	}
+	for _, g := range []*GroupSpec{s.Menu.Modifiers, items.Groups} {
+		if g == nil {
+			continue
+		}
+		if g.Options == nil {
+			return fmt.Errorf("modifiers and groups need options")
+		}
+		var err error
+		if g.nestedPath, err = ParsePath(g.Nested); err != nil {
+			return fmt.Errorf("nested %w", err)
+		}
+		parts = append(parts, &part{"groups", &g.Collection}, &part{"options", g.Options})
+	}
+	if a := items.Availability; a != nil {
+		parts = append(parts, &part{"availability", &a.Collection}, &part{"times", a.Times})
+	}
+	if m := items.Modifiers; m != nil {
+		if s.Menu.Modifiers == nil {
+			return fmt.Errorf("items.modifiers points at modifier lists but menu.modifiers is missing")
+		}
+		parts = append(parts, &part{"item_modifiers", &m.Collection}, &part{"defaults", m.Defaults})
+	}
+
+	for _, p := range parts {
+		if p.c == nil {
+			continue
+		}
+		if err := p.c.compile(p.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *HandoffSpec) compile() error {
+	if h == nil {
+		return nil
+	}
+
+	for _, m := range h.Always {
+		if _, err := koala.ParseHandoffMode(m); err != nil {
+			return fmt.Errorf("handoff.always %w", err)
+		}
+	}
+
+	h.flags = make(map[koala.HandoffMode]Path, len(h.Flags))
+	for m, path := range h.Flags {
+		mode, err := koala.ParseHandoffMode(m)
+		if err != nil {
+			return fmt.Errorf("handoff.flags %w", err)
+		}
+		if h.flags[mode], err = ParsePath(path); err != nil {
+			return fmt.Errorf("handoff.flags.%s %w", m, err)
+		}
+	}
+
+	return nil
+}
+
+// part is a collection in the spec and the name its fields are checked against
+type part struct {
+	name string
+	c    *Collection
+}
+
+func (c *Collection) compile(name string) error {
+	var err error
+	if c.selectPath, err = ParsePath(c.Select); err != nil {
+		return fmt.Errorf("%s.select %w", name, err)
+	}
+	if c.Skip != "" {
+		if c.skipPath, err = ParsePath(c.Skip); err != nil {
+			return fmt.Errorf("%s.skip %w", name, err)
+		}
+	}
+
+	return compileFields(name, c.Fields)
+}
+
+// compileFields parses the path of every field and checks it is one we know for that part of the spec
+func compileFields(name string, fields map[string]*Field) error {
+	allowed := make(map[string]bool)
+	for _, f := range fieldNames[name] {
+		allowed[f] = true
+	}
+
+	// sorted so the first mistake reported is always the same one
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !allowed[k] {
+			return fmt.Errorf("%s has no field %q, it can map %s", name, k, strings.Join(fieldNames[name], ", "))
+		}
+
+		f := fields[k]
+		var err error
+		if f.path, err = ParsePath(f.Path); err != nil {
+			return fmt.Errorf("%s.%s %w", name, k, err)
+		}
+		for _, t := range f.Transforms {
+			if err := t.check(); err != nil {
+				return fmt.Errorf("%s.%s %w", name, k, err)
+			}
+		}
+	}
+
+	return nil
+}
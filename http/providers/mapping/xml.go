@@ -0,0 +1,67 @@
+package mapping
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the key an xml elements text is kept under, attributes are kept under @ and their name
+const textKey = "#text"
+
+// decodeXML reads an xml document into the same maps, slices and strings as a json document
+// so the same paths work on both. The root element is the document,
+// every child element is kept in a list under its name since xml can repeat any element
+// EX: <restaurant id="1"><hours><period day="Monday" /></hours></restaurant>
+// is {"@id": "1", "hours": [{"period": [{"@day": "Monday"}]}]} so hours.period[*].@day selects every day
+func decodeXML(b []byte) (interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the document has no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			return readElement(dec, start)
+		}
+	}
+}
+
+// readElement reads an element and everything inside it
+func readElement(dec *xml.Decoder, start xml.StartElement) (map[string]interface{}, error) {
+	n := make(map[string]interface{}, len(start.Attr))
+	for _, a := range start.Attr {
+		n["@"+a.Name.Local] = a.Value
+	}
+
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := readElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			list, _ := n[t.Name.Local].([]interface{})
+			n[t.Name.Local] = append(list, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if s := strings.TrimSpace(text.String()); s != "" {
+				n[textKey] = s
+			}
+			return n, nil
+		}
+	}
+}
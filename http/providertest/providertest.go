@@ -0,0 +1,246 @@
+// Package providertest checks an AsyncProvider against a recorded upstream,
+// the normalized output is compared to expected json kept next to the fixtures
+//
+// Run the tests with -update to rewrite the expected files from what the providers return now,
+// then read the diff before committing it
+package providertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+	"github.com/ko1eda/apiaggregator/http"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected provider outputs")
+
+// Case is one provider run against a recorded upstream
+type Case struct {
+	// Name of the subtest EX: koala-xml-grill
+	Name string
+	// Fixtures is the directory the recorded upstream documents are in,
+	// the provider is given a client that serves file:// urls from it
+	Fixtures string
+	// Replay is a directory of responses saved with http.NewRecorder,
+	// when it is set the provider is given a replayer for it instead so real upstream urls work offline
+	Replay string
+	// Expected is the directory the expected json is in
+	Expected string
+	// Golden is set to check against the expected files of another case instead of our own,
+	// EX: the grill mapping spec has to give exactly what the koala-xml-grill case does
+	// Those files belong to the other case so -update only ever rewrites them from there
+	Golden string
+	// New builds the provider with the client
+	New func(c http.HttpGetter) http.AsyncProvider
+}
+
+// Run gets the provider info and the full menu and compares each with its expected file
+// EX: <Expected>/<Name>.info.json and <Expected>/<Name>.menu.json, or <Golden> when it is set
+// The menu has to carry the same provider info GetProviderInfo returns
+func Run(t *testing.T, c Case) {
+	t.Helper()
+
+	golden, compare := c.Golden, Compare
+	if golden == "" {
+		golden = c.Name
+	} else {
+		compare = check
+	}
+
+	t.Run(c.Name, func(t *testing.T) {
+		p := c.New(c.getter())
+		ctx := context.Background()
+
+		info, err := p.GetProviderInfo(ctx)
+		if err != nil {
+			t.Fatalf("GetProviderInfo: %v", err)
+		}
+		compare(t, filepath.Join(c.Expected, golden+".info.json"), info)
+
+		menu, err := p.GetFullMenu(ctx)
+		if err != nil {
+			t.Fatalf("GetFullMenu: %v", err)
+		}
+		compare(t, filepath.Join(c.Expected, golden+".menu.json"), menu)
+
+		if menu.ProviderInfo == nil {
+			t.Fatal("GetFullMenu returned a menu without provider info")
+		}
+		if a, b := marshal(t, menu.ProviderInfo), marshal(t, info); !bytes.Equal(a, b) {
+			t.Errorf("the menus provider info differs from GetProviderInfo\n%s", diff(b, a))
+		}
+
+		checkMenu(t, menu)
+	})
+}
+
+// the upstream failures every provider has to turn into an error of the right kind rather than a panic or a half empty menu
+var faults = []struct {
+	name  string
+	fault http.Fault
+	kind  error
+}{
+	{"server error", http.Fault{Probability: 1, Status: 500}, koala.ErrUpstreamUnavailable},
+	{"not found", http.Fault{Probability: 1, Status: 404}, koala.ErrUpstreamUnavailable},
+	{"connection refused", http.Fault{Probability: 1, Err: errors.New("connection refused")}, koala.ErrUpstreamUnavailable},
+	{"truncated body", http.Fault{Probability: 1, Truncate: true}, koala.ErrDecode},
+	{"malformed body", http.Fault{Probability: 1, Malformed: true}, koala.ErrDecode},
+}
+
+// RunFaults runs the provider against an upstream that fails in each of the ways above,
+// every call has to return an error of the faults kind and a slow upstream has to give up when the context is done
+func RunFaults(t *testing.T, c Case) {
+	t.Helper()
+
+	t.Run(c.Name+"/faults", func(t *testing.T) {
+		for _, f := range faults {
+			p := c.New(http.NewFaultGetter(c.getter(), f.fault))
+
+			if info, err := p.GetProviderInfo(context.Background()); !errors.Is(err, f.kind) {
+				t.Errorf("%s: GetProviderInfo returned %+v and %v, want a %v error", f.name, info, err, f.kind)
+			}
+			if _, err := p.GetFullMenu(context.Background()); !errors.Is(err, f.kind) {
+				t.Errorf("%s: GetFullMenu returned %v, want a %v error", f.name, err, f.kind)
+			}
+		}
+
+		p := c.New(http.NewFaultGetter(c.getter(), http.Fault{Probability: 1, Latency: time.Minute}))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if _, err := p.GetFullMenu(ctx); !errors.Is(err, koala.ErrUpstreamTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("slow upstream: got %v, want an upstream timeout caused by the deadline", err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("slow upstream: took %v to give up after the deadline", d)
+		}
+	})
+}
+
+// getter is the client the provider fetches the recorded upstream with
+func (c Case) getter() http.HttpGetter {
+	if c.Replay != "" {
+		return http.NewReplayer(c.Replay)
+	}
+
+	return http.NewClient(http.WithFileRoot(c.Fixtures))
+}
+
+// Compare marshals v and checks it against the expected file, with -update the file is rewritten instead
+func Compare(t *testing.T, path string, v interface{}) {
+	t.Helper()
+
+	if !*update {
+		check(t, path, v)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, marshal(t, v), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check marshals v and checks it against the expected file, it never writes it
+func check(t *testing.T, path string, v interface{}) {
+	t.Helper()
+
+	got := marshal(t, v)
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run the tests with -update to create it", err)
+	}
+
+	if !bytes.Equal(want, got) {
+		t.Errorf("%s does not match, run the tests with -update if the change is intended\n%s", path, diff(want, got))
+	}
+}
+
+// checkMenu checks what every provider has to hold to whatever its upstream sends
+func checkMenu(t *testing.T, m *koala.Menu) {
+	t.Helper()
+
+	for i, mi := range m.MenuItems {
+		if mi.ID == "" {
+			t.Errorf("item %d has no ID", i)
+		}
+		if mi.Category != nil && mi.Category.ID != mi.CategoryID {
+			t.Errorf("item %s has category %q but category_id %q", mi.ID, mi.Category.ID, mi.CategoryID)
+		}
+		for _, v := range mi.Variations {
+			if v.Price.Currency == "" {
+				t.Errorf("item %s variation %s has a price without a currency", mi.ID, v.ID)
+			}
+		}
+		checkGroups(t, mi.ID, mi.Modifiers)
+	}
+}
+
+func checkGroups(t *testing.T, itemID string, groups []*koala.ModifierGroup) {
+	t.Helper()
+
+	for _, g := range groups {
+		r := g.Rules
+		if r.MaxSelects > 0 && r.MinSelects > r.MaxSelects {
+			t.Errorf("item %s group %s needs %d selections but allows %d", itemID, g.ID, r.MinSelects, r.MaxSelects)
+		}
+		if r.Mandatory != (r.MinSelects > 0) {
+			t.Errorf("item %s group %s is mandatory %t with a minimum of %d", itemID, g.ID, r.Mandatory, r.MinSelects)
+		}
+		for _, o := range g.Options {
+			checkGroups(t, itemID, o.Modifiers)
+		}
+	}
+}
+
+// marshal is the form we keep expected files in, indented with a trailing newline so diffs stay readable
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(b, '\n')
+}
+
+// diff shows the first line that differs with a little context, enough to find it in the file
+func diff(want, got []byte) string {
+	w := strings.Split(string(want), "\n")
+	g := strings.Split(string(got), "\n")
+
+	line := 0
+	for line < len(w) && line < len(g) && w[line] == g[line] {
+		line++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "first difference at line %d\n", line+1)
+	for i := line; i < line+3; i++ {
+		if i < len(w) {
+			fmt.Fprintf(&b, "- %s\n", w[i])
+		}
+	}
+	for i := line; i < line+3; i++ {
+		if i < len(g) {
+			fmt.Fprintf(&b, "+ %s\n", g[i])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry maps a location ID to the provider that serves it.
+// This lets us add new locations from main without touching the handlers
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]AsyncProvider
+}
+
+// NewRegistry returns an empty registry ready for providers
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]AsyncProvider)}
+}
+
+// Register adds a provider for the given location ID.
+// We return an error rather than silently replacing a provider
+// because two providers claiming the same location is almost certainly a config mistake
+func (r *Registry) Register(locationID string, p AsyncProvider) error {
+	if locationID == "" {
+		return fmt.Errorf("RegistryErr: location ID cannot be empty")
+	}
+
+	if p == nil {
+		return fmt.Errorf("RegistryErr: provider for location %s cannot be nil", locationID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, hit := r.providers[locationID]; hit {
+		return fmt.Errorf("RegistryErr: location %s is already registered", locationID)
+	}
+
+	r.providers[locationID] = p
+
+	return nil
+}
+
+// Lookup returns the provider for a location ID and whether we found one
+func (r *Registry) Lookup(locationID string) (AsyncProvider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, hit := r.providers[locationID]
+
+	return p, hit
+}
+
+// IDs returns every registered location ID in sorted order
+// so anything built from it has a stable output
+func (r *Registry) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.providers))
+	for id := range r.providers {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	reg := NewRegistry()
+	p := &menuProvider{}
+	if err := reg.Register("1", p); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		ID   string
+		p    AsyncProvider
+		want string
+	}{
+		{"duplicate", "1", &menuProvider{}, "location 1 is already registered"},
+		{"empty ID", "", &menuProvider{}, "location ID cannot be empty"},
+		{"nil provider", "2", nil, "provider for location 2 cannot be nil"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := reg.Register(tc.ID, tc.p)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error with %s", err, tc.want)
+			}
+		})
+	}
+
+	// a failed registration leaves what was there alone
+	if got, hit := reg.Lookup("1"); !hit || got != p {
+		t.Errorf("location 1 is %v, want the first provider", got)
+	}
+	if _, hit := reg.Lookup("2"); hit {
+		t.Error("the nil provider was registered")
+	}
+	if got := reg.IDs(); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("IDs got %v, want just 1", got)
+	}
+}
+
+func TestLookupMiss(t *testing.T) {
+	if p, hit := NewRegistry().Lookup("1"); hit || p != nil {
+		t.Errorf("got %v and %v from an empty registry, want a miss", p, hit)
+	}
+}
+
+// IDs are sorted whatever order they were registered in
+func TestIDs(t *testing.T) {
+	reg := NewRegistry()
+	if got := reg.IDs(); got == nil || len(got) != 0 {
+		t.Errorf("got %#v from an empty registry, want an empty list", got)
+	}
+
+	for _, ID := range []string{"3", "10", "1", "eatery-2"} {
+		if err := reg.Register(ID, &menuProvider{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := reg.IDs(), []string{"1", "10", "3", "eatery-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
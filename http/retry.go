@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// we do a runtime check to ensure our wrapper implements HttpGetter
+var _ HttpGetter = &retryGetter{}
+
+// retryGetter retries failed GETs with a jittered exponential backoff,
+// GETs are idempotent so trying again can't do any harm upstream
+type retryGetter struct {
+	getter   HttpGetter
+	attempts int
+	backoff  time.Duration
+	max      time.Duration
+}
+
+// NewRetryGetter wraps g so a GET that fails with a network error or a 5xx/429 is tried up to attempts times in total
+// The wait before each retry is a random duration up to backoff doubled for every attempt, capped at 32 times backoff
+// EX: NewRetryGetter(client, 3, 100*time.Millisecond) waits up to 100ms then up to 200ms
+func NewRetryGetter(g HttpGetter, attempts int, backoff time.Duration) HttpGetter {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return &retryGetter{getter: g, attempts: attempts, backoff: backoff, max: 32 * backoff}
+}
+
+// Get keeps trying until it gets a response worth returning, runs out of attempts or ctx is done,
+// the last response or error is returned as is so the caller sees what actually happened
+func (r *retryGetter) Get(ctx context.Context, url string) (*http.Response, error) {
+	for i := 1; ; i++ {
+		resp, err := r.getter.Get(ctx, url)
+		if i >= r.attempts || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		// we're throwing this response away so free the connection
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(r.wait(i)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// wait is the full jitter backoff before retry i, spreading retries out
+// so a recovering upstream isn't hit by every caller at once
+func (r *retryGetter) wait(i int) time.Duration {
+	d := r.backoff << uint(i-1)
+	if d <= 0 || d > r.max {
+		d = r.max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryable reports whether the result is a failure that could go away on its own
+// Once the callers context is done there is no point trying again
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
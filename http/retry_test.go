@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptGetter answers each GET with the next status in the script, 0 is the error instead
+// once the script runs out the last answer is repeated
+type scriptGetter struct {
+	mu     sync.Mutex
+	calls  int
+	script []int
+	err    error
+}
+
+func (g *scriptGetter) Get(ctx context.Context, url string) (*http.Response, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	i := g.calls
+	if i >= len(g.script) {
+		i = len(g.script) - 1
+	}
+	g.calls++
+
+	if g.script[i] == 0 {
+		return nil, g.err
+	}
+
+	return statusResponse(ctx, url, g.script[i]), nil
+}
+
+func TestRetryGetter(t *testing.T) {
+	refused := errors.New("connection refused")
+
+	cases := []struct {
+		name     string
+		script   []int
+		attempts int
+		calls    int
+		// 0 is an error
+		status int
+	}{
+		{"ok first time", []int{200}, 3, 1, 200},
+		{"server errors until it works", []int{500, 503, 200}, 3, 3, 200},
+		{"out of attempts", []int{500}, 3, 3, 500},
+		{"too many requests", []int{429, 200}, 3, 2, 200},
+		{"network errors until it works", []int{0, 0, 200}, 3, 3, 200},
+		{"network errors out of attempts", []int{0}, 4, 4, 0},
+		{"client errors aren't retried", []int{404}, 3, 1, 404},
+		{"a single attempt", []int{500}, 1, 1, 500},
+		{"less than one attempt is one", []int{500}, 0, 1, 500},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &scriptGetter{script: tc.script, err: refused}
+			resp, err := NewRetryGetter(g, tc.attempts, time.Millisecond).Get(context.Background(), "https://example.com")
+
+			if g.calls != tc.calls {
+				t.Errorf("made %d calls, want %d", g.calls, tc.calls)
+			}
+			if tc.status == 0 {
+				if !errors.Is(err, refused) {
+					t.Errorf("got %v, want the last error %v", err, refused)
+				}
+				return
+			}
+			if err != nil || resp.StatusCode != tc.status {
+				t.Errorf("got %v %v, want a %d", resp, err, tc.status)
+			}
+		})
+	}
+}
+
+func TestRetryGetterGivesUpWhenDone(t *testing.T) {
+	g := &scriptGetter{script: []int{503}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewRetryGetter(g, 100, time.Minute).Get(ctx, "https://example.com")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v to give up after the deadline", d)
+	}
+	if g.calls >= 100 {
+		t.Errorf("made %d calls after the deadline", g.calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	backoff := 100 * time.Millisecond
+	r := NewRetryGetter(&scriptGetter{}, 10, backoff).(*retryGetter)
+
+	for i := 1; i <= 10; i++ {
+		// the limit doubles every retry until it is capped at 32 times the backoff
+		limit := backoff << uint(i-1)
+		if limit > 32*backoff {
+			limit = 32 * backoff
+		}
+
+		var longest time.Duration
+		for n := 0; n < 200; n++ {
+			d := r.wait(i)
+			if d < 0 || d >= limit {
+				t.Fatalf("retry %d waited %v, want under %v", i, d, limit)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+
+		// the jitter should use most of the range
+		if longest < limit/2 {
+			t.Errorf("retry %d waited at most %v of %v in 200 tries", i, longest, limit)
+		}
+	}
+
+	if d := NewRetryGetter(&scriptGetter{}, 3, 0).(*retryGetter).wait(1); d != 0 {
+		t.Errorf("no backoff waited %v", d)
+	}
+}
@@ -1,9 +1,15 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,20 +17,33 @@ import (
 
 // Server represents an http server
 type Server struct {
-	address            string
-	listener           net.Listener
-	router             chi.Router
-	JsonEateryProvider AsyncProvider
-	XmlGrillProvider   AsyncProvider
+	address  string
+	listener net.Listener
+	server   *http.Server
+	router   chi.Router
+	errs     chan error
+	// holds the current *Registry, it is swapped as a whole on reload
+	providers atomic.Value
+	swapMu    sync.Mutex
 }
 
 // NewServer returns a new sever instance
+// The timeouts default to 10s to read a request, 30s to write a response and 60s for an idle keep alive connection,
+// the write timeout has to be longer than the provider timeout or slow providers will be cut off mid response
 func NewServer(opts ...func(*Server)) *Server {
 	s := &Server{
 		address: ":8080",
+		server: &http.Server{
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		errs: make(chan error, 1),
 	}
 
 	s.router = chi.NewRouter()
+	s.server.Handler = s.router
+	s.providers.Store(NewRegistry())
 
 	for _, opt := range opts {
 		opt(s)
@@ -33,16 +52,52 @@ func NewServer(opts ...func(*Server)) *Server {
 	return s
 }
 
-// WithAddress sets the listening address and port for the server
-// Format is https://my-address.com:80
-// defaults to 127.0.0.1 on random port
+// WithAddress sets the host and port the server listens on, without a scheme EX: :8080 or 127.0.0.1:80
+// It defaults to :8080, every interface on port 8080, and a port of 0 lets the OS pick one (see Addr)
 func WithAddress(address string) func(*Server) {
 	return func(s *Server) {
 		s.address = address
 	}
 }
 
+// WithRegistry sets the registry the server uses to look up providers by location ID
+func WithRegistry(r *Registry) func(*Server) {
+	return func(s *Server) {
+		s.providers.Store(r)
+	}
+}
+
+// WithTimeouts sets how long the server waits to read a request, to write a response
+// and how long it keeps an idle keep alive connection open
+// A zero duration is no timeout like it is for net/http, an idle timeout of zero falls back to the read timeout
+func WithTimeouts(read, write, idle time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.server.ReadTimeout = read
+		s.server.WriteTimeout = write
+		s.server.IdleTimeout = idle
+	}
+}
+
+// Providers returns the registry in use right now
+// Handlers take it once per request so a request that started before a reload finishes on the old providers
+func (s *Server) Providers() *Registry {
+	return s.providers.Load().(*Registry)
+}
+
+// SwapProviders atomically replaces the registry and returns the old one,
+// new requests use r straight away while requests already in flight keep the registry they started with
+func (s *Server) SwapProviders(r *Registry) *Registry {
+	s.swapMu.Lock()
+	defer s.swapMu.Unlock()
+
+	old := s.Providers()
+	s.providers.Store(r)
+
+	return old
+}
+
 // Open opens the server and listens at the specifed address
+// An error listening is returned here, anything that goes wrong while serving after that comes out of Err
 func (s *Server) Open() error {
 	// create the routes for the server
 	s.routes()
@@ -51,29 +106,58 @@ func (s *Server) Open() error {
 	ln, err := net.Listen("tcp", s.address)
 
 	if err != nil {
-		return err
+		return fmt.Errorf("ServerErr: Could not listen on %s %w", s.address, err)
 	}
 
 	s.listener = ln
 
 	// Start HTTP server. Note this is non-blocking so
 	// we must block in the calling code
-	go func() { http.Serve(s.listener, s.router) }()
+	go func() {
+		// Shutdown and Close make Serve return ErrServerClosed, that is us stopping not an error
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.errs <- fmt.Errorf("ServerErr: Stopped serving on %s %w", s.address, err)
+		}
+		close(s.errs)
+	}()
 
-	log.Println("Server started listening on port " + s.address[1:] + "....")
+	log.Println("Server started listening on " + s.Addr() + "....")
 
 	return nil
 }
 
-// Close closes the socket.
-func (s *Server) Close() error {
-	if s.listener != nil {
-		defer s.listener.Close()
+// Addr returns the address the server is listening on, before Open it is the address we were given
+// EX: with 127.0.0.1:0 it is the port the OS picked once the server is open
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.address
+	}
+
+	return s.listener.Addr().String()
+}
+
+// Err returns a channel that gets the error if the server stops serving on its own,
+// it is closed without an error once the server is shut down
+func (s *Server) Err() <-chan error {
+	return s.errs
+}
+
+// Shutdown stops accepting new connections and waits for in flight requests to finish,
+// if ctx is done first the remaining connections are closed and ctx's error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return fmt.Errorf("ServerErr: Could not drain requests %w", err)
 	}
 
 	return nil
 }
 
+// Close closes the socket and any open connections straight away, use Shutdown to let requests finish
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
 // routes maps all route handlers to their respoective paths
 func (s *Server) routes() {
 	// basic middlewares for our server
@@ -82,14 +166,35 @@ func (s *Server) routes() {
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
 
-	s.router.Route("/providers/locations/", func(r chi.Router) {
+	s.router.Get(
+		"/status",
+		s.handleStatus(),
+	)
+
+	s.router.Route("/providers", func(r chi.Router) {
+		// aggregate routes that fan out to every registered provider
 		r.Get(
-			"/{id}",
-			s.handleGetProviderInfo(),
+			"/locations",
+			s.handleGetAllProviderInfo(),
 		)
 		r.Get(
-			"/{id}/menu",
-			s.handleGetFullMenu(),
+			"/menus",
+			s.handleGetAllMenus(),
 		)
+
+		r.Route("/locations/{id}", func(r chi.Router) {
+			r.Get(
+				"/",
+				s.handleGetProviderInfo(),
+			)
+			r.Get(
+				"/menu",
+				s.handleGetFullMenu(),
+			)
+			r.Post(
+				"/quote",
+				s.handleQuote(),
+			)
+		})
 	})
 }
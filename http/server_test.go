@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	koala "github.com/ko1eda/apiaggregator"
+)
+
+func TestServerOpenShutdown(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1:0"))
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasSuffix(s.Addr(), ":0") {
+		t.Fatalf("Addr is %s, want the port we are listening on", s.Addr())
+	}
+
+	resp, err := http.Get("http://" + s.Addr() + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status got %d, want 200", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err, open := <-s.Err(); open || err != nil {
+		t.Errorf("got %v after shutting down, want the channel closed", err)
+	}
+}
+
+// a zero timeout is passed through as no timeout instead of keeping the default
+func TestWithTimeouts(t *testing.T) {
+	s := NewServer(WithTimeouts(5*time.Second, 0, 0))
+
+	if s.server.ReadTimeout != 5*time.Second || s.server.WriteTimeout != 0 || s.server.IdleTimeout != 0 {
+		t.Errorf("got read %v write %v idle %v, want 5s and no write or idle timeout", s.server.ReadTimeout, s.server.WriteTimeout, s.server.IdleTimeout)
+	}
+}
+
+// blockingProvider answers with its name, once started is closed it waits for release first
+type blockingProvider struct {
+	name    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) GetProviderInfo(ctx context.Context) (*koala.ProviderInfo, error) {
+	if p.started != nil {
+		close(p.started)
+		<-p.release
+	}
+
+	return &koala.ProviderInfo{ID: "1", Name: p.name}, nil
+}
+
+func (p *blockingProvider) GetFullMenu(ctx context.Context) (*koala.Menu, error) {
+	return &koala.Menu{}, nil
+}
+
+// a request that started before a swap finishes on the old provider, one after it uses the new one
+func TestSwapProvidersInFlight(t *testing.T) {
+	old := &blockingProvider{name: "old", started: make(chan struct{}), release: make(chan struct{})}
+	reg := NewRegistry()
+	reg.Register("1", old)
+	s := NewServer(WithRegistry(reg))
+	s.routes()
+
+	done := make(chan string)
+	go func() {
+		_, body := send(s, http.MethodGet, "/providers/locations/1", "")
+		done <- body
+	}()
+	<-old.started
+
+	next := NewRegistry()
+	next.Register("1", &blockingProvider{name: "new"})
+	if got := s.SwapProviders(next); got != reg {
+		t.Error("SwapProviders didn't return the old registry")
+	}
+
+	if _, body := send(s, http.MethodGet, "/providers/locations/1", ""); !strings.Contains(body, `"new"`) {
+		t.Errorf("a request after the swap got %s, want the new provider", body)
+	}
+
+	close(old.release)
+	if body := <-done; !strings.Contains(body, `"old"`) {
+		t.Errorf("the request in flight got %s, want the old provider", body)
+	}
+}
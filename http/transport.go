@@ -1,21 +1,69 @@
 package http
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
 
 // We pass this to our providers so we can easily mock out HTTP responses during testing
+// The context is attached to the request so a cancelled caller aborts the fetch
 type HttpGetter interface {
-	Get(url string) (*http.Response, error)
+	Get(ctx context.Context, url string) (*http.Response, error)
 }
 
-// Create a new Http Client with a 30 second connection timeout
-func NewClient() *http.Client {
+// we do a runtime check to ensure our client implements HttpGetter
+var _ HttpGetter = &Client{}
+
+// Client is our default HttpGetter, it is a thin wrapper around the standard library client
+// so we can configure the transport with the same variadic options as the rest of the app
+type Client struct {
+	client    *http.Client
+	transport *http.Transport
+}
+
+// Create a new Http Client with a 30 second request timeout
+// this is a last line of defense, callers should set tighter deadlines on the context
+func NewClient(opts ...func(*Client)) *Client {
 	t := &http.Transport{
 		MaxIdleConns:    10,
 		IdleConnTimeout: 30 * time.Second,
 	}
 
-	return &http.Client{Transport: t}
+	c := &Client{
+		client:    &http.Client{Transport: t, Timeout: 30 * time.Second},
+		transport: t,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithFileRoot lets the client serve file:// urls from the given directory
+// EX: with a root of ./goldenfiles the url file:///json-eatery-menu.json reads ./goldenfiles/json-eatery-menu.json
+// This keeps our providers working offline against the golden files without changing their code
+func WithFileRoot(dir string) func(*Client) {
+	return func(c *Client) {
+		c.transport.RegisterProtocol("file", http.NewFileTransport(http.Dir(dir)))
+	}
+}
+
+// WithTimeout sets the overall timeout for a single request including reading the body
+func WithTimeout(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.client.Timeout = d
+	}
+}
+
+// Get issues a GET request to the url bound to ctx, the caller is responsible for closing the body
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client.Do(req)
 }
@@ -0,0 +1,143 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor units of its currency (cents for USD)
+// paired with the ISO 4217 currency code. Keeping whole numbers means two providers
+// that send prices in different formats can be compared and added without float rounding
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// minorUnits holds the number of decimal places for currencies that don't use two,
+// anything not listed here is assumed to have two like USD
+var minorUnits = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+	"VND": 0,
+}
+
+// MinorUnits returns the number of decimal places used by the currency
+func MinorUnits(currency string) int {
+	if n, hit := minorUnits[strings.ToUpper(currency)]; hit {
+		return n
+	}
+
+	return 2
+}
+
+// NewMoney returns money for an amount already in minor units
+// EX: NewMoney(499, "USD") is $4.99
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney converts a decimal string in major units into Money
+// EX: ParseMoney("6.0000", "USD") is 600 cents. We parse the digits ourselves instead of going
+// through a float, any precision past the currencies minor units is rounded half away from zero
+func ParseMoney(decimal, currency string) (Money, error) {
+	s := strings.TrimSpace(decimal)
+
+	// one sign at most, anything after it has to be digits so "-+5" or "--5" fail below
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("MoneyParseErr: %q is not a decimal amount", decimal)
+	}
+
+	units := MinorUnits(currency)
+
+	// pad or cut the fraction to exactly the minor units, remembering the first digit we cut for rounding
+	roundUp := len(frac) > units && frac[units] >= '5'
+	if len(frac) > units {
+		frac = frac[:units]
+	}
+	frac += strings.Repeat("0", units-len(frac))
+
+	amount, err := strconv.ParseInt("0"+whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("MoneyParseErr: %q is out of range %w", decimal, err)
+	}
+
+	if roundUp {
+		amount++
+	}
+
+	if neg {
+		amount = -amount
+	}
+
+	return NewMoney(amount, currency), nil
+}
+
+// isDigits reports whether s is only ascii digits, an empty string counts
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Add returns the sum of two amounts, adding different currencies is an error
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("MoneyCurrencyErr: cannot add %s to %s", o.Currency, m.Currency)
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns the amount multiplied by a quantity
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// IsZero reports whether the amount is zero, regardless of currency
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String formats the amount in major units followed by the currency EX: 4.99 USD
+func (m Money) String() string {
+	units := MinorUnits(m.Currency)
+
+	amount, sign := m.Amount, ""
+	if amount < 0 {
+		amount, sign = -amount, "-"
+	}
+
+	if units == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+
+	scale := int64(1)
+	for i := 0; i < units; i++ {
+		scale *= 10
+	}
+
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/scale, units, amount%scale, m.Currency)
+}
@@ -0,0 +1,83 @@
+package aggregator
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		in       string
+		currency string
+		want     int64
+	}{
+		{"6.0000", "USD", 600},
+		{"4.99", "usd", 499},
+		{"4.9", "USD", 490},
+		{"4", "USD", 400},
+		{".5", "USD", 50},
+		{"5.", "USD", 500},
+		{" 1.25 ", "USD", 125},
+		{"+1.25", "USD", 125},
+		{"-1.25", "USD", -125},
+		{"0.005", "USD", 1},
+		{"0.0049", "USD", 0},
+		{"-0.005", "USD", -1},
+		{"9.995", "USD", 1000},
+		{"1200", "JPY", 1200},
+		{"1200.5", "JPY", 1201},
+		{"1.2345", "KWD", 1235},
+	}
+
+	for _, tc := range cases {
+		m, err := ParseMoney(tc.in, tc.currency)
+		if err != nil {
+			t.Errorf("ParseMoney(%q, %s): %v", tc.in, tc.currency, err)
+			continue
+		}
+		if m.Amount != tc.want {
+			t.Errorf("ParseMoney(%q, %s) is %d, want %d", tc.in, tc.currency, m.Amount, tc.want)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, in := range []string{"", " ", ".", "-", "+", "-+5", "+-5", "--5", "++5", "5-", "1.2.3", "1,50", "abc", "$5", "1e3", "99999999999999999999"} {
+		if m, err := ParseMoney(in, "USD"); err == nil {
+			t.Errorf("ParseMoney(%q) is %v, want an error", in, m)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(499, "usd"), "4.99 USD"},
+		{NewMoney(5, "USD"), "0.05 USD"},
+		{NewMoney(0, "USD"), "0.00 USD"},
+		{NewMoney(-125, "USD"), "-1.25 USD"},
+		{NewMoney(-5, "USD"), "-0.05 USD"},
+		{NewMoney(1200, "JPY"), "1200 JPY"},
+		{NewMoney(1235, "KWD"), "1.235 KWD"},
+	}
+
+	for _, tc := range cases {
+		if got := tc.m.String(); got != tc.want {
+			t.Errorf("%#v is %q, want %q", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	sum, err := NewMoney(499, "USD").Add(NewMoney(1, "USD"))
+	if err != nil || sum != NewMoney(500, "USD") {
+		t.Errorf("got %v %v, want 5.00 USD", sum, err)
+	}
+
+	if _, err := NewMoney(1, "USD").Add(NewMoney(1, "JPY")); err == nil {
+		t.Error("adding USD to JPY did not fail")
+	}
+
+	if got := NewMoney(250, "USD").Mul(3); got != NewMoney(750, "USD") {
+		t.Errorf("got %v, want 7.50 USD", got)
+	}
+}
@@ -0,0 +1,274 @@
+package aggregator
+
+import "fmt"
+
+// Order is a cart a client wants validated and priced against a menu
+type Order struct {
+	Items []*OrderItem `json:"items"`
+}
+
+// OrderItem is one line of the cart
+type OrderItem struct {
+	ItemID string `json:"item_id"`
+	// Can be left out when the item only has one variation
+	VariationID string `json:"variation_id,omitempty"`
+	// Defaults to 1 when left out
+	Quantity int            `json:"quantity,omitempty"`
+	Options  []*OrderOption `json:"options,omitempty"`
+}
+
+// OrderOption is a chosen modifier option, any choices for the groups
+// that option opens are nested under it the same way they are on the menu
+// An option can only be listed once among its siblings, more of it is its quantity
+type OrderOption struct {
+	OptionID string `json:"option_id"`
+	// Defaults to 1 when left out, only groups with quantity limits care about more
+	Quantity int            `json:"quantity,omitempty"`
+	Options  []*OrderOption `json:"options,omitempty"`
+}
+
+// Quote is the priced result of an order, it is only Valid when there are no errors
+type Quote struct {
+	Valid  bool          `json:"valid"`
+	Lines  []*QuoteLine  `json:"lines"`
+	Total  Money         `json:"total"`
+	Errors []*QuoteError `json:"errors,omitempty"`
+}
+
+// QuoteLine is the price of a single order item
+type QuoteLine struct {
+	ItemID      string `json:"item_id"`
+	Name        string `json:"name"`
+	VariationID string `json:"variation_id"`
+	Quantity    int    `json:"quantity"`
+	// price of one item with all of its options
+	UnitPrice Money `json:"unit_price"`
+	Total     Money `json:"total"`
+}
+
+// Error codes for a quote
+const (
+	QuoteEmptyOrder       = "empty_order"
+	QuoteInvalidQuantity  = "invalid_quantity"
+	QuoteUnknownItem      = "unknown_item"
+	QuoteItemDisabled     = "item_disabled"
+	QuoteUnknownVariation = "unknown_variation"
+	QuoteUnknownOption    = "unknown_option"
+	QuoteOptionDisabled   = "option_disabled"
+	QuoteDuplicateOption  = "duplicate_option"
+	QuoteTooFewSelected   = "too_few_selected"
+	QuoteTooManySelected  = "too_many_selected"
+	QuoteQuantityTooLow   = "quantity_too_low"
+	QuoteQuantityTooHigh  = "quantity_too_high"
+	QuoteCurrencyMismatch = "currency_mismatch"
+)
+
+// QuoteError is a single problem with an order,
+// Line is the index of the order item it belongs to or -1 for the whole order
+type QuoteError struct {
+	Line    int    `json:"line"`
+	Code    string `json:"code"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// Quote validates the order against the menu and prices every line
+// Lines with errors are still returned with whatever we could price so the client can show them,
+// but they are left out of the total
+func (m *Menu) Quote(o *Order) *Quote {
+	q := &Quote{Lines: []*QuoteLine{}}
+
+	if o == nil || len(o.Items) == 0 {
+		q.addError(-1, QuoteEmptyOrder, "", "The order has no items")
+		return q
+	}
+
+	items := make(map[string]*MenuItem, len(m.MenuItems))
+	for _, mi := range m.MenuItems {
+		items[mi.ID] = mi
+	}
+
+	for i, oi := range o.Items {
+		errs := len(q.Errors)
+		line := q.quoteItem(i, oi, items)
+		if line == nil {
+			// keep the lines lined up with the order items even when we couldn't price one
+			q.Lines = append(q.Lines, &QuoteLine{ItemID: oi.ItemID, VariationID: oi.VariationID, Quantity: oi.Quantity})
+			continue
+		}
+		q.Lines = append(q.Lines, line)
+
+		// only lines without errors count toward the total
+		if len(q.Errors) > errs {
+			continue
+		}
+
+		if q.Total.Currency == "" {
+			q.Total = Money{Currency: line.Total.Currency}
+		}
+
+		total, err := q.Total.Add(line.Total)
+		if err != nil {
+			q.addError(i, QuoteCurrencyMismatch, oi.ItemID, err.Error())
+			continue
+		}
+		q.Total = total
+	}
+
+	q.Valid = len(q.Errors) == 0
+
+	return q
+}
+
+// quoteItem checks and prices a single order item, it returns nil when the item can't be priced at all
+func (q *Quote) quoteItem(i int, oi *OrderItem, items map[string]*MenuItem) *QuoteLine {
+	mi, hit := items[oi.ItemID]
+	if !hit {
+		q.addError(i, QuoteUnknownItem, oi.ItemID, fmt.Sprintf("Item %s is not on the menu", oi.ItemID))
+		return nil
+	}
+
+	if mi.Disabled || mi.Category != nil && mi.Category.Disabled {
+		q.addError(i, QuoteItemDisabled, mi.ID, fmt.Sprintf("%s is not available", mi.Name))
+	}
+
+	qty := oi.Quantity
+	if qty == 0 {
+		qty = 1
+	}
+	if qty < 0 {
+		q.addError(i, QuoteInvalidQuantity, mi.ID, fmt.Sprintf("Quantity for %s must be positive", mi.Name))
+		return nil
+	}
+
+	v := findVariation(mi, oi.VariationID)
+	if v == nil {
+		q.addError(i, QuoteUnknownVariation, oi.VariationID, fmt.Sprintf("Pick a valid variation for %s", mi.Name))
+		return nil
+	}
+
+	unit := q.quoteOptions(i, v.Price, mi.Modifiers, oi.Options)
+
+	return &QuoteLine{
+		ItemID:      mi.ID,
+		Name:        mi.Name,
+		VariationID: v.ID,
+		Quantity:    qty,
+		UnitPrice:   unit,
+		Total:       unit.Mul(int64(qty)),
+	}
+}
+
+// findVariation returns the variation with the ID, an empty ID picks the only variation if there is exactly one
+func findVariation(mi *MenuItem, ID string) *Variation {
+	if ID == "" {
+		if len(mi.Variations) == 1 {
+			return mi.Variations[0]
+		}
+		return nil
+	}
+
+	for _, v := range mi.Variations {
+		if v.ID == ID {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// quoteOptions checks the chosen options against the groups they belong to and adds their cost to price
+// It calls itself for the groups opened by every chosen option so nested choices are checked the same way
+func (q *Quote) quoteOptions(i int, price Money, groups []*ModifierGroup, chosen []*OrderOption) Money {
+	// find the group each chosen option belongs to
+	type selection struct {
+		count    int
+		quantity int
+	}
+	selected := make(map[*ModifierGroup]*selection, len(groups))
+	for _, g := range groups {
+		selected[g] = &selection{}
+	}
+
+	// an option is picked once with a quantity, listing it twice would count and charge it twice
+	seen := make(map[string]bool, len(chosen))
+	for _, c := range chosen {
+		g, opt := findOption(groups, c.OptionID)
+		if opt == nil {
+			q.addError(i, QuoteUnknownOption, c.OptionID, fmt.Sprintf("Option %s can't be chosen here", c.OptionID))
+			continue
+		}
+		if seen[opt.ID] {
+			q.addError(i, QuoteDuplicateOption, opt.ID, fmt.Sprintf("%s is chosen more than once, set its quantity instead", opt.Name))
+			continue
+		}
+		seen[opt.ID] = true
+
+		if g.Disabled || opt.Disabled {
+			q.addError(i, QuoteOptionDisabled, opt.ID, fmt.Sprintf("%s is not available", opt.Name))
+		}
+
+		qty := c.Quantity
+		if qty == 0 {
+			qty = 1
+		}
+		if qty < 0 {
+			q.addError(i, QuoteInvalidQuantity, opt.ID, fmt.Sprintf("Quantity for %s must be positive", opt.Name))
+			continue
+		}
+
+		selected[g].count++
+		selected[g].quantity += qty
+
+		cost := q.quoteOptions(i, opt.Cost, opt.Modifiers, c.Options)
+
+		total, err := price.Add(cost.Mul(int64(qty)))
+		if err != nil {
+			q.addError(i, QuoteCurrencyMismatch, opt.ID, err.Error())
+			continue
+		}
+		price = total
+	}
+
+	// every group is checked, including the ones nothing was picked from
+	for _, g := range groups {
+		s, r := selected[g], g.Rules
+
+		if s.count < r.MinSelects {
+			q.addError(i, QuoteTooFewSelected, g.ID, fmt.Sprintf("%s needs at least %d selected", g.Name, r.MinSelects))
+		}
+		if r.MaxSelects > 0 && s.count > r.MaxSelects {
+			q.addError(i, QuoteTooManySelected, g.ID, fmt.Sprintf("%s allows at most %d selected", g.Name, r.MaxSelects))
+		}
+
+		// quantity limits only apply once something is picked from the group
+		if s.count == 0 {
+			continue
+		}
+		if r.MinQuantity > 0 && s.quantity < r.MinQuantity {
+			q.addError(i, QuoteQuantityTooLow, g.ID, fmt.Sprintf("%s needs a total quantity of at least %d", g.Name, r.MinQuantity))
+		}
+		if r.MaxQuantity > 0 && s.quantity > r.MaxQuantity {
+			q.addError(i, QuoteQuantityTooHigh, g.ID, fmt.Sprintf("%s allows a total quantity of at most %d", g.Name, r.MaxQuantity))
+		}
+	}
+
+	return price
+}
+
+// findOption returns the option with the ID and the group it is in, only searching the groups given
+func findOption(groups []*ModifierGroup, ID string) (*ModifierGroup, *ModifierOption) {
+	for _, g := range groups {
+		for _, o := range g.Options {
+			if o.ID == ID {
+				return g, o
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (q *Quote) addError(line int, code, ID, msg string) {
+	q.Errors = append(q.Errors, &QuoteError{Line: line, Code: code, ID: ID, Message: msg})
+}
@@ -0,0 +1,250 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+)
+
+// wingsMenu has an item whose size option opens a flavor group, and a drink with two variations
+func wingsMenu() *Menu {
+	usd := func(n int64) Money { return NewMoney(n, "USD") }
+
+	flavors := &ModifierGroup{
+		ID:    "flavors",
+		Name:  "Choose Flavors:",
+		Rules: SelectionRules{SelectionType: SelectionMultiple, Mandatory: true, MinSelects: 1, MaxSelects: 2, MaxQuantity: 3},
+		Options: []*ModifierOption{
+			{ID: "bbq", Name: "BBQ", Cost: usd(0)},
+			{ID: "buffalo", Name: "Buffalo", Cost: usd(0)},
+			{ID: "truffle", Name: "Truffle", Cost: usd(150)},
+			{ID: "ghost", Name: "Ghost Pepper", Disabled: true, Cost: usd(0)},
+		},
+	}
+	size := &ModifierGroup{
+		ID:    "size",
+		Name:  "Choose Size:",
+		Rules: SelectionRules{SelectionType: SelectionSingle, Mandatory: true, MinSelects: 1, MaxSelects: 1},
+		Options: []*ModifierOption{
+			{ID: "10", Name: "10 Boneless", Cost: usd(0), Modifiers: []*ModifierGroup{flavors}},
+			{ID: "20", Name: "20 Boneless", Cost: usd(800), Modifiers: []*ModifierGroup{flavors}},
+		},
+	}
+	extras := &ModifierGroup{
+		ID:    "extras",
+		Name:  "Extras",
+		Rules: SelectionRules{SelectionType: SelectionMultiple},
+		Options: []*ModifierOption{
+			{ID: "ranch", Name: "Ranch", Cost: usd(75)},
+		},
+	}
+
+	return &Menu{MenuItems: []*MenuItem{
+		{
+			ID:         "wings",
+			Name:       "Boneless Wings",
+			Variations: []*Variation{{ID: "wings-reg", Name: "Regular", Price: usd(999)}},
+			Modifiers:  []*Modifier{size, extras},
+		},
+		{
+			ID:   "soda",
+			Name: "Soda",
+			Variations: []*Variation{
+				{ID: "soda-s", Name: "Small", Price: usd(199)},
+				{ID: "soda-l", Name: "Large", Price: usd(299)},
+			},
+		},
+		{
+			ID:         "special",
+			Name:       "Special",
+			Disabled:   true,
+			Variations: []*Variation{{ID: "special-reg", Name: "Regular", Price: usd(1500)}},
+		},
+	}}
+}
+
+// wings is an order line for the wings with the size and flavors given
+func wings(size string, flavors ...*OrderOption) *OrderItem {
+	return &OrderItem{ItemID: "wings", Options: []*OrderOption{{OptionID: size, Options: flavors}}}
+}
+
+func opt(ID string, qty int) *OrderOption {
+	return &OrderOption{OptionID: ID, Quantity: qty}
+}
+
+func TestQuote(t *testing.T) {
+	cases := []struct {
+		name  string
+		order *Order
+		// totals in cents, only checked for valid quotes
+		lines []int64
+		total int64
+		// the error codes in order, empty for a valid quote
+		errs []string
+	}{
+		{
+			name:  "nested options",
+			order: &Order{Items: []*OrderItem{wings("20", opt("bbq", 1), opt("truffle", 2))}},
+			// 9.99 + 8.00 for the size + 2 * 1.50 truffle
+			lines: []int64{2099},
+			total: 2099,
+		},
+		{
+			name: "quantities and variations",
+			order: &Order{Items: []*OrderItem{
+				{ItemID: "soda", VariationID: "soda-l", Quantity: 3},
+				{ItemID: "wings", Quantity: 2, Options: []*OrderOption{
+					{OptionID: "10", Options: []*OrderOption{opt("buffalo", 0)}},
+					{OptionID: "ranch"},
+				}},
+			}},
+			lines: []int64{897, 2148},
+			total: 3045,
+		},
+		{
+			name:  "empty order",
+			order: &Order{},
+			errs:  []string{QuoteEmptyOrder},
+		},
+		{
+			name:  "unknown item",
+			order: &Order{Items: []*OrderItem{{ItemID: "pizza"}}},
+			errs:  []string{QuoteUnknownItem},
+		},
+		{
+			name:  "disabled item",
+			order: &Order{Items: []*OrderItem{{ItemID: "special"}}},
+			errs:  []string{QuoteItemDisabled},
+		},
+		{
+			name:  "negative quantity",
+			order: &Order{Items: []*OrderItem{{ItemID: "soda", VariationID: "soda-s", Quantity: -1}}},
+			errs:  []string{QuoteInvalidQuantity},
+		},
+		{
+			name:  "variation has to be picked",
+			order: &Order{Items: []*OrderItem{{ItemID: "soda"}}},
+			errs:  []string{QuoteUnknownVariation},
+		},
+		{
+			name:  "unknown variation",
+			order: &Order{Items: []*OrderItem{{ItemID: "soda", VariationID: "soda-xl"}}},
+			errs:  []string{QuoteUnknownVariation},
+		},
+		{
+			name:  "unknown option",
+			order: &Order{Items: []*OrderItem{wings("10", opt("bbq", 1), opt("mango", 1))}},
+			errs:  []string{QuoteUnknownOption},
+		},
+		{
+			// bbq is in the group the size opens, not on the item itself
+			name:  "option from a nested group at the top",
+			order: &Order{Items: []*OrderItem{{ItemID: "wings", Options: []*OrderOption{{OptionID: "10", Options: []*OrderOption{opt("bbq", 1)}}, {OptionID: "bbq"}}}}},
+			errs:  []string{QuoteUnknownOption},
+		},
+		{
+			name:  "disabled option",
+			order: &Order{Items: []*OrderItem{wings("10", opt("ghost", 1))}},
+			errs:  []string{QuoteOptionDisabled},
+		},
+		{
+			name:  "mandatory group left out",
+			order: &Order{Items: []*OrderItem{{ItemID: "wings"}}},
+			errs:  []string{QuoteTooFewSelected},
+		},
+		{
+			name:  "nested mandatory group left out",
+			order: &Order{Items: []*OrderItem{wings("10")}},
+			errs:  []string{QuoteTooFewSelected},
+		},
+		{
+			name:  "too many selected",
+			order: &Order{Items: []*OrderItem{{ItemID: "wings", Options: []*OrderOption{{OptionID: "10", Options: []*OrderOption{opt("bbq", 1)}}, {OptionID: "20", Options: []*OrderOption{opt("bbq", 1)}}}}}},
+			errs:  []string{QuoteTooManySelected},
+		},
+		{
+			name:  "too many nested selected",
+			order: &Order{Items: []*OrderItem{wings("10", opt("bbq", 1), opt("buffalo", 1), opt("truffle", 1))}},
+			errs:  []string{QuoteTooManySelected},
+		},
+		{
+			name:  "quantity too high",
+			order: &Order{Items: []*OrderItem{wings("10", opt("bbq", 2), opt("buffalo", 2))}},
+			errs:  []string{QuoteQuantityTooHigh},
+		},
+		{
+			// listed twice it would count as two picks and be charged twice
+			name:  "option repeated",
+			order: &Order{Items: []*OrderItem{wings("10", opt("truffle", 1), opt("truffle", 1))}},
+			errs:  []string{QuoteDuplicateOption},
+		},
+		{
+			name: "same option under different parents",
+			order: &Order{Items: []*OrderItem{
+				wings("10", opt("truffle", 1)),
+				wings("20", opt("truffle", 2)),
+			}},
+			// 9.99 + 1.50, then 9.99 + 8.00 + 2 * 1.50
+			lines: []int64{1149, 2099},
+			total: 3248,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := wingsMenu().Quote(tc.order)
+
+			var codes []string
+			for _, e := range q.Errors {
+				codes = append(codes, e.Code)
+			}
+			if !reflect.DeepEqual(codes, tc.errs) {
+				t.Fatalf("got errors %v, want %v", codes, tc.errs)
+			}
+			if q.Valid != (len(tc.errs) == 0) {
+				t.Errorf("valid is %t with errors %v", q.Valid, codes)
+			}
+			if tc.order != nil && len(q.Lines) != len(tc.order.Items) {
+				t.Errorf("got %d lines for %d order items", len(q.Lines), len(tc.order.Items))
+			}
+			if !q.Valid {
+				return
+			}
+
+			for i, want := range tc.lines {
+				if got := q.Lines[i].Total; got != NewMoney(want, "USD") {
+					t.Errorf("line %d total is %v, want %d cents", i, got, want)
+				}
+			}
+			if q.Total != NewMoney(tc.total, "USD") {
+				t.Errorf("total is %v, want %d cents", q.Total, tc.total)
+			}
+		})
+	}
+}
+
+func TestQuoteBadLine(t *testing.T) {
+	q := wingsMenu().Quote(&Order{Items: []*OrderItem{
+		{ItemID: "soda", VariationID: "soda-s", Quantity: 2},
+		{ItemID: "pizza"},
+	}})
+
+	if e := q.Errors[0]; e.Line != 1 || e.ID != "pizza" {
+		t.Errorf("got error for line %d %s, want line 1 pizza", e.Line, e.ID)
+	}
+	if q.Total != NewMoney(398, "USD") {
+		t.Errorf("total is %v, want only the sodas", q.Total)
+	}
+	if l := q.Lines[0]; l.UnitPrice != NewMoney(199, "USD") || l.Quantity != 2 {
+		t.Errorf("got line %+v, want 2 small sodas at 1.99", l)
+	}
+}
+
+func TestQuoteCurrencyMismatch(t *testing.T) {
+	m := wingsMenu()
+	m.MenuItems = append(m.MenuItems, &MenuItem{ID: "yen", Name: "Yen", Variations: []*Variation{{ID: "yen-reg", Price: NewMoney(500, "JPY")}}})
+
+	q := m.Quote(&Order{Items: []*OrderItem{{ItemID: "soda", VariationID: "soda-s"}, {ItemID: "yen"}}})
+	if q.Valid || len(q.Errors) != 1 || q.Errors[0].Code != QuoteCurrencyMismatch {
+		t.Errorf("got %+v, want a currency mismatch", q.Errors)
+	}
+}